@@ -20,10 +20,16 @@
 package goauld
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha1"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/piprate/json-gold/ld"
 	"go.etcd.io/bbolt"
@@ -32,6 +38,9 @@ import (
 // ErrNoIndex is returned when no index is found to query against
 var ErrNoIndex = errors.New("no index found")
 
+// ErrNoIdentity is returned when a document doesn't have exactly one value at the identity TermPath
+var ErrNoIdentity = errors.New("document has no unique identity")
+
 // DocumentWalker defines a function that is used as a callback for matching documents.
 // The key will be the document Reference (hash) and the value will be the raw document bytes
 type DocumentWalker func(key Reference, value []byte) error
@@ -50,28 +59,76 @@ type Collection interface {
 	NewIndex(name string, parts ...FieldIndexer) Index
 	// AddIndex to this collection. It doesn't matter if the index already exists.
 	// If you want to override an index (by name) drop it first.
+	// The index is built in batches, it blocks until all indices have been built.
 	AddIndex(index ...Index) error
+	// BuildIndex adds the index to this collection like AddIndex, but builds it in the background.
+	// Documents are indexed in batches, so writers are not blocked during the build.
+	// The index is only used by queries once the build has completed.
+	// Cancelling the context stops the build and removes the partial index.
+	BuildIndex(ctx context.Context, index Index) *IndexBuild
 	// DropIndex by name
 	DropIndex(name string) error
 	// Add a set of documents to this collection
 	Add(jsonSet []Document) error
+	// BatchAdd is like Add, but concurrent calls are combined into a single transaction.
+	// An error of one call fails the combined transaction, the other calls are then retried individually.
+	BatchAdd(jsonSet []Document) error
 	// Get returns the data for the given key or nil if not found
-	Get(ref Reference) (Document, error)
+	// The document can be compacted or framed with OutputOptions.
+	Get(ref Reference, options ...OutputOption) (Document, error)
+	// Upsert adds the document or replaces the document with the same identity.
+	// The identity is the root node @id, unless configured otherwise with WithIdentityTermPath.
+	// It returns true if a document has been replaced and ErrNoIdentity when the document doesn't have a single identity value.
+	Upsert(doc Document) (bool, error)
 	// Delete a document
+	// It returns ErrReferenceNotDerivable when the reference isn't derived from the document, see WithTimeOrderedReferences.
 	Delete(doc Document) error
+	// BatchDelete is like Delete, but concurrent calls are combined into a single transaction.
+	BatchDelete(jsonSet []Document) error
+	// DeleteByReference deletes the document stored under the given reference.
+	// The stored document is used to remove the index entries.
+	DeleteByReference(ref Reference) error
+	// DeleteWhere deletes all documents that match the query within a single transaction.
+	// It returns the number of deleted documents.
+	// returns context errors when the context has been cancelled or deadline has exceeded, nothing is deleted in that case.
+	DeleteWhere(ctx context.Context, query Query) (int, error)
 	// Find queries the collection for documents
 	// returns ErrNoIndex when no suitable index can be found
 	// returns context errors when the context has been cancelled or deadline has exceeded.
 	// passing ctx prevents adding too many records to the result set.
-	Find(ctx context.Context, query Query) ([]Document, error)
-	// Reference uses the configured reference function to generate a reference of the function
+	// The documents can be compacted or framed with OutputOptions.
+	Find(ctx context.Context, query Query, options ...OutputOption) ([]Document, error)
+	// Reference uses the configured reference function to generate a reference of the function.
+	// It returns nil when the reference function can't create a reference for the document, see WithCanonicalReferences.
 	Reference(doc Document) Reference
 	// Iterate over documents that match the given query
 	Iterate(query Query, walker DocumentWalker) error
 	// IndexIterate is used for iterating over indexed values. The query keys must match exactly with all the FieldIndexer.Name() of an index
 	// returns ErrNoIndex when no suitable index can be found
 	IndexIterate(query Query, fn ReferenceScanFn) error
-	// todo
+	// Verify checks the indices against the stored documents and reports dangling references, missing entries and orphaned keys.
+	// Indices created by NewIndex are checked, other Index implementations are skipped.
+	Verify(ctx context.Context) (VerifyReport, error)
+	// Repair is like Verify but also fixes the reported entries within the same transaction.
+	// Only the affected entries are rewritten, the indices are not rebuilt.
+	Repair(ctx context.Context) (VerifyReport, error)
+	// Rekey stores all documents under the reference of the configured reference function and records the function for the collection.
+	// It's used to change the reference function of a collection with documents, writes return ErrReferenceFuncMismatch until it's done.
+	// All documents are processed within a single transaction.
+	// It returns an error wrapping ErrReferenceConflict when documents get the same reference.
+	Rekey(ctx context.Context) error
+	// Import reads documents from the reader in the given format and adds them in batches, each batch uses its own transaction.
+	// Documents that can't be parsed or processed are skipped and reported in the ImportReport.
+	// It returns an error when the input can't be read, batches committed before the error remain.
+	Import(ctx context.Context, reader io.Reader, format Format) (ImportReport, error)
+	// Export writes the documents that match the query to the writer in the given format within a single read transaction.
+	// All documents are exported when the query is nil.
+	Export(ctx context.Context, writer io.Writer, query Query, format Format) error
+	// Select executes a SPARQL query, parsed with ParseSPARQL, on the documents of the collection.
+	// returns context errors when the context has been cancelled or deadline has exceeded.
+	Select(ctx context.Context, query *SPARQLQuery) ([]Solution, error)
+	// ValuesAtPath returns the values of the document at the given TermPath.
+	// A TermPath with the DereferenceTerm is resolved against the documents stored in the collection, within a read transaction.
 	ValuesAtPath(document Document, termPath TermPath) ([]Scalar, error)
 }
 
@@ -90,12 +147,60 @@ func defaultReferenceCreator(doc Document) Reference {
 	return b
 }
 
+// CollectionOption is the option function for configuring a collection
+type CollectionOption func(collection *collection)
+
+// WithIdentityTermPath sets the TermPath that identifies documents when using Upsert.
+// The default is the @id of the root node. A DereferenceTerm is resolved within the transaction of the Upsert.
+func WithIdentityTermPath(termPath TermPath) CollectionOption {
+	return func(collection *collection) {
+		collection.identityPath = termPath
+	}
+}
+
+// WithWorkers sets the number of goroutines that process documents before they're added.
+// The default is GOMAXPROCS.
+func WithWorkers(workers int) CollectionOption {
+	return func(collection *collection) {
+		collection.workers = workers
+	}
+}
+
+// WithIndexBatchSize sets the number of documents that are indexed per transaction when an index is built.
+func WithIndexBatchSize(batchSize int) CollectionOption {
+	return func(collection *collection) {
+		collection.batchSize = batchSize
+	}
+}
+
+// WithImportBatchSize sets the number of documents that are committed per transaction by Import.
+func WithImportBatchSize(batchSize int) CollectionOption {
+	return func(collection *collection) {
+		collection.importBatchSize = batchSize
+	}
+}
+
 type collection struct {
-	Name              string `json:"name"`
-	db                *bbolt.DB
-	IndexList         []Index `json:"indices"`
-	refMake           ReferenceFunc
+	Name      string `json:"name"`
+	db        *bbolt.DB
+	IndexList []Index `json:"indices"`
+	refMake   ReferenceFunc
+	// refCreate is used instead of refMake when set, writes fail when it returns an error
+	refCreate func(doc Document) (Reference, error)
+	// refName is the name of refMake, it's stored with the collection
+	refName           string
+	identityPath      TermPath
+	batchSize         int
+	importBatchSize   int
+	workers           int
+	storeExpanded     bool
+	nodeIndex         bool
+	documentLoader    ld.DocumentLoader
 	documentProcessor *ld.JsonLdProcessor
+	// indexMutex guards IndexList and builds. It must only be acquired within a transaction, never the other way around.
+	indexMutex sync.RWMutex
+	// builds contains the indices that are being built, by name
+	builds map[string]*IndexBuild
 }
 
 func (c *collection) NewIndex(name string, parts ...FieldIndexer) Index {
@@ -108,41 +213,9 @@ func (c *collection) NewIndex(name string, parts ...FieldIndexer) Index {
 
 func (c *collection) AddIndex(indexes ...Index) error {
 	for _, index := range indexes {
-		for _, i := range c.IndexList {
-			if i.Name() == index.Name() {
-				return nil
-			}
-		}
-
-		if err := c.db.Update(func(tx *bbolt.Tx) error {
-			bucket, err := tx.CreateBucketIfNotExists([]byte(c.Name))
-			if err != nil {
-				return err
-			}
-
-			// skip existing
-			if b := bucket.Bucket(index.BucketName()); b != nil {
-				return nil
-			}
-
-			gBucket, err := bucket.CreateBucketIfNotExists(documentBucketByteRef())
-			if err != nil {
-				return err
-			}
-
-			cur := gBucket.Cursor()
-			for ref, rawDoc := cur.First(); ref != nil; ref, rawDoc = cur.Next() {
-				if err := index.Add(bucket, ref, rawDoc); err != nil {
-					return err
-				}
-			}
-
-			return nil
-		}); err != nil {
+		if err := c.BuildIndex(context.Background(), index).Wait(context.Background()); err != nil {
 			return err
 		}
-
-		c.IndexList = append(c.IndexList, index)
 	}
 
 	return nil
@@ -150,11 +223,20 @@ func (c *collection) AddIndex(indexes ...Index) error {
 
 func (c *collection) DropIndex(name string) error {
 	return c.db.Update(func(tx *bbolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte(c.Name))
+		bucket, err := c.createBucket(tx)
 		if err != nil {
 			return err
 		}
 
+		c.indexMutex.Lock()
+		defer c.indexMutex.Unlock()
+
+		// stop a running build, it removes the partial index itself
+		if build, ok := c.builds[name]; ok {
+			build.cancel()
+			delete(c.builds, name)
+		}
+
 		var newIndices = make([]Index, len(c.IndexList))
 		j := 0
 		for _, i := range c.IndexList {
@@ -170,20 +252,201 @@ func (c *collection) DropIndex(name string) error {
 	})
 }
 
+// indices returns the indices that can be used for querying
+func (c *collection) indices() []Index {
+	c.indexMutex.RLock()
+	defer c.indexMutex.RUnlock()
+
+	return append([]Index{}, c.IndexList...)
+}
+
+// writeIndices returns the indices that must be updated when writing documents, including indices that are being built.
+// It must be called within the write transaction, so an index build can't miss the write.
+func (c *collection) writeIndices() []Index {
+	c.indexMutex.RLock()
+	defer c.indexMutex.RUnlock()
+
+	result := append([]Index{}, c.IndexList...)
+	for _, build := range c.builds {
+		result = append(result, build.index)
+	}
+	return result
+}
+
 func (c *collection) Reference(doc Document) Reference {
 	return c.refMake(doc)
 }
 
 // Add a json document set to the store.
 // this uses a single transaction per set.
+// The JSON-LD processing is done in parallel before the transaction is started.
 func (c *collection) Add(jsonSet []Document) error {
+	prepared, err := c.prepare(jsonSet)
+	if err != nil {
+		return err
+	}
+
 	return c.db.Update(func(tx *bbolt.Tx) error {
-		return c.add(tx, jsonSet)
+		return c.addPrepared(tx, prepared)
 	})
 }
 
 func (c *collection) add(tx *bbolt.Tx, jsonSet []Document) error {
-	bucket, err := tx.CreateBucketIfNotExists([]byte(c.Name))
+	prepared := make([]preparedDocument, len(jsonSet))
+	for j, doc := range jsonSet {
+		ref, err := c.reference(doc)
+		if err != nil {
+			return err
+		}
+		prepared[j] = preparedDocument{ref: ref, doc: doc}
+	}
+	return c.addPrepared(tx, prepared)
+}
+
+// BatchAdd is like Add, but concurrent calls are combined into a single transaction.
+// The JSON-LD processing is done before the transaction is started.
+func (c *collection) BatchAdd(jsonSet []Document) error {
+	prepared, err := c.prepare(jsonSet)
+	if err != nil {
+		return err
+	}
+
+	return c.db.Batch(func(tx *bbolt.Tx) error {
+		return c.addPrepared(tx, prepared)
+	})
+}
+
+// BatchDelete is like Delete, but concurrent calls are combined into a single transaction.
+// The JSON-LD processing is done before the transaction is started.
+func (c *collection) BatchDelete(jsonSet []Document) error {
+	if err := c.checkDerivable(); err != nil {
+		return err
+	}
+	prepared, err := c.prepare(jsonSet)
+	if err != nil {
+		return err
+	}
+
+	return c.db.Batch(func(tx *bbolt.Tx) error {
+		if err := c.checkReferenceFunc(tx); err != nil {
+			return err
+		}
+		for _, p := range prepared {
+			if err := c.deletePrepared(tx, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// preparedDocument is a document with its reference and the keys for each index (by name).
+// Indices without keys are processed during the transaction.
+type preparedDocument struct {
+	ref  Reference
+	doc  Document
+	keys map[string][]Key
+	// expanded is the expanded form of the document, it's nil if it hasn't been computed
+	expanded []interface{}
+}
+
+// indexKeys returns the prepared keys for the index or computes them
+func (p preparedDocument) indexKeys(i Index) ([]Key, error) {
+	if keys, ok := p.keys[i.Name()]; ok {
+		return keys, nil
+	}
+	return documentKeys(i, p.doc, p.expanded)
+}
+
+// prepare computes the references and the index keys outside a transaction.
+// Documents are processed concurrently by a pool of workers.
+// Indices added in the meantime are handled within the transaction.
+// It returns the first error encountered.
+func (c *collection) prepare(jsonSet []Document) ([]preparedDocument, error) {
+	prepared, errs := c.prepareEach(jsonSet)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return prepared, nil
+}
+
+// prepareEach is like prepare but returns an error for each document, nil if the document was prepared successfully
+func (c *collection) prepareEach(jsonSet []Document) ([]preparedDocument, []error) {
+	indices := c.writeIndices()
+	prepared := make([]preparedDocument, len(jsonSet))
+	errs := make([]error, len(jsonSet))
+
+	workers := c.workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	// the references are created in the order of the documents, so time ordered references follow the insertion order.
+	// Canonical references don't depend on the order and require JSON-LD processing, the workers create them.
+	refs := make([]Reference, len(jsonSet))
+	if c.refCreate == nil {
+		for j, doc := range jsonSet {
+			refs[j] = c.refMake(doc)
+		}
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers && w < len(jsonSet); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				prepared[j], errs[j] = c.prepareDocument(indices, refs[j], jsonSet[j])
+			}
+		}()
+	}
+
+	for j := range jsonSet {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+
+	return prepared, errs
+}
+
+// prepareDocument computes the keys of the given indices for a single document, the reference is created when it's nil
+func (c *collection) prepareDocument(indices []Index, ref Reference, doc Document) (preparedDocument, error) {
+	var err error
+	if ref == nil {
+		if ref, err = c.reference(doc); err != nil {
+			return preparedDocument{}, err
+		}
+	}
+	p := preparedDocument{
+		ref:  ref,
+		doc:  doc,
+		keys: map[string][]Key{},
+	}
+	// the document is expanded once for all indices
+	if len(indices) > 0 || c.storeExpanded || c.nodeIndex {
+		if p.expanded, err = c.expand(doc); err != nil {
+			return p, err
+		}
+	}
+	for _, i := range indices {
+		if !hasDocumentKeys(i) {
+			continue
+		}
+		keys, err := documentKeys(i, doc, p.expanded)
+		if err != nil {
+			return p, err
+		}
+		p.keys[i.Name()] = keys
+	}
+	return p, nil
+}
+
+func (c *collection) addPrepared(tx *bbolt.Tx, prepared []preparedDocument) error {
+	bucket, err := c.createBucket(tx)
 	if err != nil {
 		return err
 	}
@@ -193,28 +456,114 @@ func (c *collection) add(tx *bbolt.Tx, jsonSet []Document) error {
 		return err
 	}
 
-	for _, doc := range jsonSet {
-		ref := c.refMake(doc)
+	if err = c.checkReferenceFunc(tx); err != nil {
+		return err
+	}
+
+	indices := c.writeIndices()
+	for _, p := range prepared {
+		// another document with the same reference is replaced, so its index entries, expanded form and nodes are removed first
+		if stored := docBucket.Get(p.ref); stored != nil && !bytes.Equal(stored, p.doc) {
+			// the value is only valid until it's deleted
+			if err = c.deleteDocument(tx, p.ref, append(Document{}, stored...)); err != nil {
+				return err
+			}
+		}
 
 		// indices
 		// buckets are cached within tx
-		for _, i := range c.IndexList {
-			err = i.Add(bucket, ref, doc)
+		for _, i := range indices {
+			if !hasDocumentKeys(i) {
+				if err = i.Add(bucket, p.ref, p.doc); err != nil {
+					return err
+				}
+				continue
+			}
+			keys, err := p.indexKeys(i)
 			if err != nil {
 				return err
 			}
+			iBucket, err := bucket.CreateBucketIfNotExists(i.BucketName())
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if err = addRefToBucket(iBucket, key, p.ref); err != nil {
+					return err
+				}
+			}
 		}
 
-		err = docBucket.Put(ref, doc)
+		err = docBucket.Put(p.ref, p.doc)
 		if err != nil {
 			return err
 		}
+		if err = c.putExpanded(bucket, p); err != nil {
+			return err
+		}
+		if err = c.putNodes(bucket, p); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (c *collection) Find(ctx context.Context, query Query) ([]Document, error) {
+func (c *collection) Upsert(doc Document) (bool, error) {
+	var replaced bool
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		replaced, err = c.upsert(tx, doc)
+		return err
+	})
+	return replaced, err
+}
+
+func (c *collection) upsert(tx *bbolt.Tx, doc Document) (bool, error) {
+	identityPath := c.identityPath
+	if identityPath.IsEmpty() {
+		identityPath = NewTermPath(IDTerm)
+	}
+
+	ids, err := c.valuesAtPath(tx, doc, identityPath)
+	if err != nil {
+		return false, err
+	}
+	if len(ids) != 1 {
+		return false, ErrNoIdentity
+	}
+
+	// find the previous versions within the same transaction
+	previous, err := c.matchingReferences(context.Background(), tx, New(Eq(identityPath, ids[0])))
+	if err != nil {
+		return false, err
+	}
+
+	for _, ref := range previous {
+		if err = c.deleteByReference(tx, ref); err != nil {
+			return false, err
+		}
+	}
+
+	return len(previous) > 0, c.add(tx, []Document{doc})
+}
+
+func (c *collection) Find(ctx context.Context, query Query, options ...OutputOption) ([]Document, error) {
+	var docs []Document
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		docs, err = c.find(ctx, tx, query)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// processed outside the transaction
+	return c.output(docs, options)
+}
+
+func (c *collection) find(ctx context.Context, tx *bbolt.Tx, query Query) ([]Document, error) {
 	docs := make([]Document, 0)
 	walker := func(key Reference, value []byte) error {
 		// stop iteration when needed
@@ -222,11 +571,12 @@ func (c *collection) Find(ctx context.Context, query Query) ([]Document, error)
 			return err
 		}
 
-		docs = append(docs, value)
+		// the value is only valid during the transaction
+		docs = append(docs, append(Document{}, value...))
 		return nil
 	}
 
-	if err := c.Iterate(query, walker); err != nil {
+	if err := c.iterate(tx, query, walker); err != nil {
 		return nil, err
 	}
 
@@ -234,15 +584,26 @@ func (c *collection) Find(ctx context.Context, query Query) ([]Document, error)
 }
 
 func (c *collection) Iterate(query Query, fn DocumentWalker) error {
+	return c.db.View(func(tx *bbolt.Tx) error {
+		return c.iterate(tx, query, fn)
+	})
+}
+
+func (c *collection) iterate(tx *bbolt.Tx, query Query, fn DocumentWalker) error {
 	plan, err := c.queryPlan(query)
 	if err != nil {
 		return err
 	}
-	if err = plan.execute(fn); err != nil {
-		return err
-	}
 
-	return nil
+	return plan.executeTx(tx, fn)
+}
+
+// iterateOrScan is like iterate, but it scans all documents when the query is nil
+func (c *collection) iterateOrScan(tx *bbolt.Tx, query Query, fn DocumentWalker) error {
+	if query == nil {
+		return fullTableScanQueryPlan{queryPlanBase: queryPlanBase{collection: c}}.executeTx(tx, fn)
+	}
+	return c.iterate(tx, query, fn)
 }
 
 // IndexIterate uses a query to loop over all keys and Entries in an index. It skips the resultScan and collect phase
@@ -272,28 +633,143 @@ func (c *collection) Delete(doc Document) error {
 }
 
 func (c *collection) delete(tx *bbolt.Tx, doc Document) error {
-	bucket := tx.Bucket([]byte(c.Name))
-	if bucket == nil {
+	if err := c.checkDerivable(); err != nil {
+		return err
+	}
+	if err := c.checkReferenceFunc(tx); err != nil {
+		return err
+	}
+	ref, err := c.reference(doc)
+	if err != nil {
+		return err
+	}
+	return c.deleteDocument(tx, ref, doc)
+}
+
+func (c *collection) DeleteByReference(ref Reference) error {
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		return c.deleteByReference(tx, ref)
+	})
+}
+
+// deleteByReference loads the stored document to remove the index entries
+func (c *collection) deleteByReference(tx *bbolt.Tx, ref Reference) error {
+	docBucket := c.documentBucket(tx)
+	if docBucket == nil {
+		return nil
+	}
+	doc := docBucket.Get(ref)
+	if doc == nil {
 		return nil
 	}
 
-	ref := c.refMake(doc)
+	// the value is only valid until it's deleted
+	return c.deleteDocument(tx, ref, append(Document{}, doc...))
+}
+
+func (c *collection) DeleteWhere(ctx context.Context, query Query) (int, error) {
+	var count int
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		count, err = c.deleteWhere(ctx, tx, query)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (c *collection) deleteWhere(ctx context.Context, tx *bbolt.Tx, query Query) (int, error) {
+	refs, err := c.matchingReferences(ctx, tx, query)
+	if err != nil {
+		return 0, err
+	}
+	for _, ref := range refs {
+		if err = c.deleteByReference(tx, ref); err != nil {
+			return 0, err
+		}
+	}
+	return len(refs), nil
+}
+
+// matchingReferences returns the references of all documents matching the query within the given transaction
+func (c *collection) matchingReferences(ctx context.Context, tx *bbolt.Tx, query Query) ([]Reference, error) {
+	plan, err := c.queryPlan(query)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]Reference, 0)
+	err = plan.executeTx(tx, func(key Reference, value []byte) error {
+		// stop iteration when needed
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		refs = append(refs, append(Reference{}, key...))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+func (c *collection) deleteDocument(tx *bbolt.Tx, ref Reference, doc Document) error {
+	return c.deletePrepared(tx, preparedDocument{ref: ref, doc: doc})
+}
+
+func (c *collection) deletePrepared(tx *bbolt.Tx, p preparedDocument) error {
+	bucket := c.bucket(tx)
+	if bucket == nil {
+		return nil
+	}
 
 	docBucket := c.documentBucket(tx)
 	if docBucket == nil {
 		return nil
 	}
-	err := docBucket.Delete(ref)
+	// the stored expanded form matches the index entries of the stored document
+	if p.expanded == nil {
+		var err error
+		if p.expanded, err = c.storedExpanded(tx, p.ref); err != nil {
+			return err
+		}
+	}
+
+	err := docBucket.Delete(p.ref)
 	if err != nil {
 		return err
 	}
+	if err = deleteExpanded(bucket, p.ref); err != nil {
+		return err
+	}
+	if err = c.deleteNodes(bucket, p); err != nil {
+		return err
+	}
 
 	// indices
-	for _, i := range c.IndexList {
-		err = i.Delete(bucket, ref, doc)
+	for _, i := range c.writeIndices() {
+		if !hasDocumentKeys(i) {
+			if err = i.Delete(bucket, p.ref, p.doc); err != nil {
+				return err
+			}
+			continue
+		}
+		iBucket := bucket.Bucket(i.BucketName())
+		if iBucket == nil {
+			continue
+		}
+		keys, err := p.indexKeys(i)
 		if err != nil {
 			return err
 		}
+		for _, key := range keys {
+			if err = removeRefFromBucket(iBucket, key, p.ref); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -336,7 +812,7 @@ func (c *collection) findIndex(query Query) Index {
 	var cIndex Index
 	var cMatch float64
 
-	for _, i := range c.IndexList {
+	for _, i := range c.indices() {
 		m := i.IsMatch(query)
 		if m > cMatch {
 			cIndex = i
@@ -347,29 +823,58 @@ func (c *collection) findIndex(query Query) Index {
 	return cIndex
 }
 
-func (c *collection) Get(key Reference) (Document, error) {
-	var err error
-	var data []byte
-
-	err = c.db.View(func(tx *bbolt.Tx) error {
-		bucket := c.documentBucket(tx)
-		if bucket == nil {
-			return nil
-		}
+func (c *collection) Get(key Reference, options ...OutputOption) (Document, error) {
+	var data Document
 
-		data = bucket.Get(key)
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		data = c.get(tx, key)
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
+	docs, err := c.output([]Document{data}, options)
+	if err != nil {
+		return nil, err
+	}
+	return docs[0], nil
+}
+
+// get returns a copy of the document or nil if not found
+func (c *collection) get(tx *bbolt.Tx, key Reference) Document {
+	bucket := c.documentBucket(tx)
+	if bucket == nil {
+		return nil
+	}
+
+	data := bucket.Get(key)
 	if data == nil {
-		return nil, nil
+		return nil
 	}
+	return append(Document{}, data...)
+}
 
-	return data, err
+// bucket returns the bucket of the collection or nil if it doesn't exist.
+// A collection with a reserved name doesn't have a bucket.
+func (c *collection) bucket(tx *bbolt.Tx) *bbolt.Bucket {
+	if isReservedCollectionName(c.Name) {
+		return nil
+	}
+	return tx.Bucket([]byte(c.Name))
+}
+
+// createBucket returns the bucket of the collection, it's created when it doesn't exist.
+// It returns ErrReservedName for a collection with a reserved name.
+func (c *collection) createBucket(tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	if isReservedCollectionName(c.Name) {
+		return nil, fmt.Errorf("%w: %s", ErrReservedName, c.Name)
+	}
+	return tx.CreateBucketIfNotExists([]byte(c.Name))
 }
 
 func (c *collection) documentBucket(tx *bbolt.Tx) *bbolt.Bucket {
-	bucket := tx.Bucket([]byte(c.Name))
+	bucket := c.bucket(tx)
 	if bucket == nil {
 		return nil
 	}
@@ -377,21 +882,61 @@ func (c *collection) documentBucket(tx *bbolt.Tx) *bbolt.Bucket {
 }
 
 func (c *collection) ValuesAtPath(document Document, termPath TermPath) ([]Scalar, error) {
+	if !hasDereference(termPath) {
+		// the transaction is only used to resolve the DereferenceTerm
+		return c.valuesAtPath(nil, document, termPath)
+	}
+
+	var values []Scalar
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		values, err = c.valuesAtPath(tx, document, termPath)
+		return err
+	})
+	return values, err
+}
+
+// valuesAtPath is like ValuesAtPath, but resolves the DereferenceTerm within the given transaction
+func (c *collection) valuesAtPath(tx *bbolt.Tx, document Document, termPath TermPath) ([]Scalar, error) {
 	if len(termPath.Terms) == 0 {
 		return []Scalar{}, nil
 	}
 
-	var input interface{}
-	if err := json.Unmarshal(document, &input); err != nil {
+	expanded, err := c.expand(document)
+	if err != nil {
 		return nil, err
 	}
+	return c.pathEvaluator(tx)(expanded, termPath)
+}
 
-	expanded, err := c.documentProcessor.Expand(input, nil)
-	if err != nil {
-		return nil, err
+// jsonLdOptions returns the JSON-LD processor options for this collection, it sets the configured document loader.
+func (c *collection) jsonLdOptions() *ld.JsonLdOptions {
+	options := ld.NewJsonLdOptions("")
+	if c.documentLoader != nil {
+		options.DocumentLoader = c.documentLoader
 	}
+	return options
+}
+
+// valuesFromDocumentAtPath evaluates the termPath against the top-level nodes of the expanded document, see TermPath.
+func valuesFromDocumentAtPath(expanded []interface{}, termPath TermPath) []Scalar {
+	if termPath.Head() == AllGraphsTerm {
+		return valuesFromNodesAtPath(expanded, termPath.Tail())
+	}
+	return valuesFromSliceAtPath(expanded, termPath)
+}
 
-	return valuesFromSliceAtPath(expanded, termPath), nil
+// valuesFromNodesAtPath evaluates the termPath against the given nodes and the nodes of their named graphs
+func valuesFromNodesAtPath(nodes []interface{}, termPath TermPath) []Scalar {
+	result := valuesFromSliceAtPath(nodes, termPath)
+	for _, n := range nodes {
+		if node, ok := n.(map[string]interface{}); ok {
+			if graph, ok := node["@graph"].([]interface{}); ok {
+				result = append(result, valuesFromNodesAtPath(graph, termPath)...)
+			}
+		}
+	}
+	return result
 }
 
 func valuesFromSliceAtPath(expanded []interface{}, termPath TermPath) []Scalar {
@@ -436,6 +981,63 @@ func valuesFromMapAtPath(expanded map[string]interface{}, termPath TermPath) []S
 		}
 	}
 
+	if id, ok := idFromFilterTerm(termPath.Head()); ok {
+		if expanded["@id"] != id {
+			return nil
+		}
+		return valuesFromMapAtPath(expanded, termPath.Tail())
+	}
+
+	if graphIRI, ok := graphFromTerm(termPath.Head()); ok {
+		graph, isGraph := expanded["@graph"].([]interface{})
+		if !isGraph || expanded["@id"] != graphIRI {
+			return nil
+		}
+		if termPath.Tail().IsEmpty() {
+			return []Scalar{ScalarMustParse(graphIRI)}
+		}
+		return valuesFromSliceAtPath(graph, termPath.Tail())
+	}
+
+	if typeIRI, ok := typeFromFilterTerm(termPath.Head()); ok {
+		if !hasType(expanded, typeIRI) {
+			return nil
+		}
+		return valuesFromMapAtPath(expanded, termPath.Tail())
+	}
+
+	if propertyIRI, ok := reverseFromTerm(termPath.Head()); ok {
+		reverse, ok := expanded["@reverse"].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		next, ok := reverse[propertyIRI].([]interface{})
+		if !ok {
+			return nil
+		}
+		return valuesFromSliceAtPath(next, termPath.Tail())
+	}
+
+	switch termPath.Head() {
+	case IDTerm:
+		id, ok := expanded["@id"].(string)
+		if !ok || !termPath.Tail().IsEmpty() {
+			return nil
+		}
+		return []Scalar{ScalarMustParse(id)}
+	case TypeTerm:
+		if !termPath.Tail().IsEmpty() {
+			return nil
+		}
+		return typesOf(expanded)
+	case WildcardTerm:
+		return valuesFromTermsAtPath(expanded, termPath.Tail())
+	case DescendantTerm:
+		// zero levels deep, followed by one or more levels deep
+		result := valuesFromMapAtPath(expanded, termPath.Tail())
+		return append(result, valuesFromTermsAtPath(expanded, termPath)...)
+	}
+
 	if value, ok := expanded[termPath.Head()]; ok {
 		// the value should now be a slice
 		next, ok := value.([]interface{})
@@ -447,3 +1049,57 @@ func valuesFromMapAtPath(expanded map[string]interface{}, termPath TermPath) []S
 
 	return nil
 }
+
+// valuesFromTermsAtPath continues the search for the termPath in the values of all terms of the expanded node.
+// Keywords (@id, @type, etc.) are skipped. Terms are visited in lexical order.
+func valuesFromTermsAtPath(expanded map[string]interface{}, termPath TermPath) []Scalar {
+	terms := make([]string, 0, len(expanded))
+	for term := range expanded {
+		if !strings.HasPrefix(term, "@") {
+			terms = append(terms, term)
+		}
+	}
+	sort.Strings(terms)
+
+	result := make([]Scalar, 0)
+	for _, term := range terms {
+		if next, ok := expanded[term].([]interface{}); ok {
+			result = append(result, valuesFromSliceAtPath(next, termPath)...)
+		}
+	}
+
+	return result
+}
+
+// hasType returns true if the @type of the expanded node contains the given IRI
+func hasType(expanded map[string]interface{}, typeIRI string) bool {
+	types, ok := expanded["@type"].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, t := range types {
+		if t == typeIRI {
+			return true
+		}
+	}
+	return false
+}
+
+// typesOf returns the @type IRIs of an expanded node.
+// The @type of a value object is its datatype, not a node type, so value objects don't have types.
+func typesOf(expanded map[string]interface{}) []Scalar {
+	if _, ok := expanded["@value"]; ok {
+		return nil
+	}
+	types, ok := expanded["@type"].([]interface{})
+	if !ok {
+		return nil
+	}
+	result := make([]Scalar, 0, len(types))
+	for _, t := range types {
+		if typeIRI, ok := t.(string); ok {
+			result = append(result, ScalarMustParse(typeIRI))
+		}
+	}
+	return result
+}
@@ -23,10 +23,16 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"math"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/piprate/json-gold/ld"
 	"github.com/stretchr/testify/assert"
 	"go.etcd.io/bbolt"
 )
@@ -131,6 +137,178 @@ func TestCollection_Add(t *testing.T) {
 	})
 }
 
+func TestCollection_BatchAdd(t *testing.T) {
+	identifierTermPath := NewTermPath("http://schema.org/identifier")
+
+	t.Run("ok - concurrent calls", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := c.NewIndex(t.Name(), NewFieldIndexer(identifierTermPath))
+		_ = c.AddIndex(i)
+
+		wg := sync.WaitGroup{}
+		errs := make([]error, 50)
+		for j := range errs {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				doc := []byte(fmt.Sprintf(`{"@context": ["http://schema.org/"], "identifier": "%d"}`, j))
+				errs[j] = c.BatchAdd([]Document{doc})
+			}(j)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			assert.NoError(t, err)
+		}
+		assertSize(t, db, documentBucket, 50)
+		assertIndexSize(t, db, i, 50)
+	})
+
+	t.Run("error - only the failing call returns an error", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.AddIndex(testIndex(t, c))
+
+		wg := sync.WaitGroup{}
+		var errValid, errInvalid error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errValid = c.BatchAdd([]Document{jsonLdExample})
+		}()
+		go func() {
+			defer wg.Done()
+			errInvalid = c.BatchAdd([]Document{[]byte("{")})
+		}()
+		wg.Wait()
+
+		assert.NoError(t, errValid)
+		assert.Error(t, errInvalid)
+		assertSize(t, db, documentBucket, 1)
+	})
+}
+
+func TestCollection_BatchDelete(t *testing.T) {
+	db := testDB(t)
+	c := createCollection(db)
+	i := testIndex(t, c)
+	_ = c.AddIndex(i)
+	_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+
+	wg := sync.WaitGroup{}
+	errs := make([]error, 2)
+	for j, doc := range []Document{jsonLdExample, jsonLdExample2} {
+		wg.Add(1)
+		go func(j int, doc Document) {
+			defer wg.Done()
+			errs[j] = c.BatchDelete([]Document{doc})
+		}(j, doc)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assertSize(t, db, documentBucket, 0)
+	assertIndexEmpty(t, db, i)
+}
+
+func TestCollection_Upsert(t *testing.T) {
+	nameTermPath := NewTermPath("http://schema.org/name")
+	v1 := []byte(`{"@context": ["http://schema.org/"], "@id": "http://example.com/jane", "name": "Jane Doe"}`)
+	v2 := []byte(`{"@context": ["http://schema.org/"], "@id": "http://example.com/jane", "name": "Jane Smith"}`)
+
+	t.Run("ok - insert", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+
+		replaced, err := c.Upsert(v1)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.False(t, replaced)
+		assertSize(t, db, documentBucket, 1)
+	})
+
+	t.Run("ok - replace", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := c.NewIndex(t.Name(), NewFieldIndexer(nameTermPath))
+		_ = c.AddIndex(i)
+		_, _ = c.Upsert(v1)
+
+		replaced, err := c.Upsert(v2)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, replaced)
+		assertSize(t, db, documentBucket, 1)
+		assertIndexSize(t, db, i, 1)
+		docs, _ := c.Find(context.TODO(), New(Eq(nameTermPath, ScalarMustParse("Jane Smith"))))
+		assert.Len(t, docs, 1)
+		docs, _ = c.Find(context.TODO(), New(Eq(nameTermPath, ScalarMustParse("Jane Doe"))))
+		assert.Len(t, docs, 0)
+	})
+
+	t.Run("ok - replace a document added without upsert", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.Add([]Document{v1, jsonLdExample})
+
+		replaced, err := c.Upsert(v2)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, replaced)
+		assertSize(t, db, documentBucket, 2)
+	})
+
+	t.Run("ok - same document", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_, _ = c.Upsert(v1)
+
+		replaced, err := c.Upsert(v1)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, replaced)
+		assertSize(t, db, documentBucket, 1)
+	})
+
+	t.Run("ok - custom identity", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		WithIdentityTermPath(NewTermPath("http://schema.org/telephone"))(c)
+		_, _ = c.Upsert(jsonLdExample)
+
+		replaced, err := c.Upsert(jsonLdExample2)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, replaced)
+		d, _ := c.Get(defaultReferenceCreator(jsonLdExample2))
+		assert.NotNil(t, d)
+		assertSize(t, db, documentBucket, 1)
+	})
+
+	t.Run("error - no identity", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+
+		_, err := c.Upsert(jsonLdExample)
+
+		assert.Equal(t, ErrNoIdentity, err)
+		assertSize(t, db, documentBucket, 0)
+	})
+}
+
 func TestCollection_Delete(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		db := testDB(t)
@@ -161,6 +339,171 @@ func TestCollection_Delete(t *testing.T) {
 	})
 }
 
+func TestCollection_IndexIntegrity(t *testing.T) {
+	db := testDB(t)
+	c := createCollection(db)
+	indices := []Index{
+		c.NewIndex("name", NewFieldIndexer(NewTermPath("http://schema.org/name"))),
+		c.NewIndex("compound",
+			NewFieldIndexer(NewTermPath("http://schema.org/name")),
+			NewFieldIndexer(NewTermPath("http://schema.org/weight")),
+			NewFieldIndexer(NewTermPath("http://schema.org/children", "http://schema.org/name")),
+		),
+		c.NewIndex("tokenized",
+			NewFieldIndexer(NewTermPath("http://schema.org/jobTitle"), TokenizerOption(WhiteSpaceTokenizer), TransformerOption(ToLower)),
+		),
+		c.NewIndex("descendants", NewFieldIndexer(NewTermPath(DescendantTerm, "http://schema.org/name"))),
+	}
+	_ = c.AddIndex(indices...)
+
+	random := rand.New(rand.NewSource(1))
+	names := []string{"Jane", "John", "Jack", ""}
+	titles := []string{"Professor", "Dr. Professor", "head of Department", ""}
+	randomDoc := func(n int) Document {
+		fields := []string{`"@context": ["http://schema.org/"]`, fmt.Sprintf(`"identifier": "%d"`, n)}
+		if name := names[random.Intn(len(names))]; name != "" {
+			fields = append(fields, fmt.Sprintf(`"name": "%s"`, name))
+		}
+		if title := titles[random.Intn(len(titles))]; title != "" {
+			fields = append(fields, fmt.Sprintf(`"jobTitle": "%s"`, title))
+		}
+		if random.Intn(2) == 0 {
+			fields = append(fields, fmt.Sprintf(`"weight": %d`, random.Intn(4)))
+		}
+		children := make([]string, random.Intn(3))
+		for j := range children {
+			children[j] = fmt.Sprintf(`{"name": "%s"}`, names[random.Intn(len(names)-1)])
+		}
+		fields = append(fields, fmt.Sprintf(`"children": [%s]`, strings.Join(children, ",")))
+		return []byte(fmt.Sprintf("{%s}", strings.Join(fields, ",")))
+	}
+
+	docs := make([]Document, 50)
+	for j := range docs {
+		docs[j] = randomDoc(j)
+	}
+	if err := c.Add(docs); err != nil {
+		t.Fatal(err)
+	}
+
+	random.Shuffle(len(docs), func(i, j int) {
+		docs[i], docs[j] = docs[j], docs[i]
+	})
+	for _, doc := range docs {
+		if err := c.Delete(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertSize(t, db, documentBucket, 0)
+	for _, i := range indices {
+		assertIndexEmpty(t, db, i)
+	}
+}
+
+func TestCollection_DeleteByReference(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := testIndex(t, c)
+		_ = c.AddIndex(i)
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+
+		err := c.DeleteByReference(defaultReferenceCreator(jsonLdExample))
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assertIndexSize(t, db, i, 1)
+		assertSize(t, db, documentBucket, 1)
+	})
+
+	t.Run("ok - not added", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.Add([]Document{jsonLdExample2})
+
+		err := c.DeleteByReference(defaultReferenceCreator(jsonLdExample))
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assertSize(t, db, documentBucket, 1)
+	})
+}
+
+func TestCollection_DeleteWhere(t *testing.T) {
+	telephoneTermPath := NewTermPath("http://schema.org/telephone")
+	telephone := ScalarMustParse("(425) 123-4567")
+
+	t.Run("ok - using an index", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := c.NewIndex(t.Name(), NewFieldIndexer(telephoneTermPath))
+		_ = c.AddIndex(i)
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2, jsonLdTypedExample})
+
+		count, err := c.DeleteWhere(context.TODO(), New(Eq(telephoneTermPath, telephone)))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 2, count)
+		assertIndexSize(t, db, i, 1)
+		assertSize(t, db, documentBucket, 1)
+	})
+
+	t.Run("ok - using a full table scan", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2, jsonLdTypedExample})
+
+		count, err := c.DeleteWhere(context.TODO(), New(IsType("http://schema.org/Person")))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 3, count)
+		assertSize(t, db, documentBucket, 0)
+	})
+
+	t.Run("ok - no matches", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.Add([]Document{jsonLdExample})
+
+		count, err := c.DeleteWhere(context.TODO(), New(IsType("http://schema.org/Organization")))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 0, count)
+		assertSize(t, db, documentBucket, 1)
+	})
+
+	t.Run("error - ctx cancelled", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.Add([]Document{jsonLdExample})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := c.DeleteWhere(ctx, New(Eq(telephoneTermPath, telephone)))
+
+		assert.Equal(t, context.Canceled, err)
+		assertSize(t, db, documentBucket, 1)
+	})
+
+	t.Run("error - no query", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+
+		_, err := c.DeleteWhere(context.TODO(), nil)
+
+		assert.Equal(t, ErrNoQuery, err)
+	})
+}
+
 func TestCollection_Find(t *testing.T) {
 	db := testDB(t)
 	nameTermPath := NewTermPath("http://schema.org/name")
@@ -299,7 +642,8 @@ func TestCollection_Find(t *testing.T) {
 		_ = c.AddIndex(i)
 		_ = c.Add([]Document{jsonLdExample})
 		q := New(Eq(nameTermPath, janeDoe))
-		ctx, _ := context.WithTimeout(context.Background(), time.Nanosecond)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+		defer cancel()
 
 		_, err := c.Find(ctx, q)
 
@@ -311,6 +655,84 @@ func TestCollection_Find(t *testing.T) {
 	})
 }
 
+func TestCollection_FindByType(t *testing.T) {
+	db := testDB(t)
+	c := createCollection(db)
+	i := c.NewIndex(t.Name(), NewFieldIndexer(NewTermPath(TypeTerm)))
+	_ = c.AddIndex(i)
+	_ = c.Add([]Document{jsonLdExample, jsonLdMultiTypeExample, jsonLdTypedExample})
+
+	t.Run("ok - all persons", func(t *testing.T) {
+		docs, err := c.Find(context.TODO(), New(IsType("http://schema.org/Person")))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Len(t, docs, 3)
+	})
+
+	t.Run("ok - second type", func(t *testing.T) {
+		docs, err := c.Find(context.TODO(), New(IsType("http://schema.org/Patient")))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		if assert.Len(t, docs, 1) {
+			assert.Equal(t, Document(jsonLdMultiTypeExample), docs[0])
+		}
+	})
+
+	t.Run("ok - type of nested node is not matched", func(t *testing.T) {
+		docs, err := c.Find(context.TODO(), New(IsType("http://schema.org/Organization")))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Len(t, docs, 0)
+	})
+
+	t.Run("ok - combined with other query parts", func(t *testing.T) {
+		q := New(IsType("http://schema.org/Person")).And(Eq(NewTermPath("http://schema.org/name"), ScalarMustParse("Jack Doe")))
+
+		docs, err := c.Find(context.TODO(), q)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Len(t, docs, 1)
+	})
+}
+
+func TestCollection_FindWithDelimiterInValue(t *testing.T) {
+	db := testDB(t)
+	c := createCollection(db)
+	weightTermPath := NewTermPath("http://schema.org/weight")
+	nameTermPath := NewTermPath("http://schema.org/name")
+	i := c.NewIndex(t.Name(), NewFieldIndexer(weightTermPath), NewFieldIndexer(nameTermPath))
+	_ = c.AddIndex(i)
+	// the float64 encoding of 4 contains the KeyDelimiter
+	doc := []byte(`{"@context": ["http://schema.org/"], "name": "Jane Doe", "weight": 4}`)
+	_ = c.Add([]Document{doc, jsonLdExample})
+
+	t.Run("ok", func(t *testing.T) {
+		q := New(Eq(weightTermPath, ScalarMustParse(4.0))).And(Eq(nameTermPath, ScalarMustParse("Jane Doe")))
+
+		docs, err := c.Find(context.TODO(), q)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		if assert.Len(t, docs, 1) {
+			assert.Equal(t, Document(doc), docs[0])
+		}
+	})
+}
+
 func TestCollection_Iterate(t *testing.T) {
 	s := testStore(t)
 	c := createCollection(s.db)
@@ -464,6 +886,307 @@ func TestCollection_ValuesAtPath(t *testing.T) {
 		assert.Len(t, values, 1)
 		assert.Equal(t, "John Doe", string(values[0].Bytes()))
 	})
+
+	t.Run("ok - find through a wildcard", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdExample, NewTermPath(WildcardTerm, "http://schema.org/name"))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 1)
+		assert.Equal(t, "John Doe", string(values[0].Bytes()))
+	})
+
+	t.Run("ok - find all descendants", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdExample, NewTermPath(DescendantTerm, "http://schema.org/name"))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 2)
+		assert.Equal(t, "Jane Doe", string(values[0].Bytes()))
+		assert.Equal(t, "John Doe", string(values[1].Bytes()))
+	})
+
+	t.Run("ok - descendant at the end of the path", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdExample, NewTermPath("http://schema.org/children", DescendantTerm))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 1)
+		assert.Equal(t, "John Doe", string(values[0].Bytes()))
+	})
+
+	t.Run("ok - only descend into nodes of a type", func(t *testing.T) {
+		organization := TypeFilterTerm("http://schema.org/Organization")
+		values, err := c.ValuesAtPath(jsonLdTypedExample, NewTermPath(WildcardTerm, organization, "http://schema.org/name"))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 1)
+		assert.Equal(t, "Nuts", string(values[0].Bytes()))
+	})
+
+	t.Run("ok - type filter on descendants", func(t *testing.T) {
+		person := TypeFilterTerm("http://schema.org/Person")
+		values, err := c.ValuesAtPath(jsonLdTypedExample, NewTermPath(DescendantTerm, person, "http://schema.org/name"))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 2)
+		assert.Equal(t, "Jane Doe", string(values[0].Bytes()))
+		assert.Equal(t, "John Doe", string(values[1].Bytes()))
+	})
+
+	t.Run("ok - type filter on root without match", func(t *testing.T) {
+		organization := TypeFilterTerm("http://schema.org/Organization")
+		values, err := c.ValuesAtPath(jsonLdTypedExample, NewTermPath(organization, "http://schema.org/name"))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 0)
+	})
+
+	t.Run("ok - find the id of the root node", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdReverseExample, NewTermPath(IDTerm))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 1)
+		assert.Equal(t, "http://example.com/nuts", string(values[0].Bytes()))
+	})
+
+	t.Run("ok - find the type of the root node", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdExample, NewTermPath(TypeTerm))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 1)
+		assert.Equal(t, "http://schema.org/Person", string(values[0].Bytes()))
+	})
+
+	t.Run("ok - find multiple types", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdMultiTypeExample, NewTermPath(TypeTerm))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 2)
+		assert.Equal(t, "http://schema.org/Person", string(values[0].Bytes()))
+		assert.Equal(t, "http://schema.org/Patient", string(values[1].Bytes()))
+	})
+
+	t.Run("ok - find the types of nested nodes", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdTypedExample, NewTermPath(WildcardTerm, TypeTerm))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 2)
+		assert.Equal(t, "http://schema.org/Person", string(values[0].Bytes()))
+		assert.Equal(t, "http://schema.org/Organization", string(values[1].Bytes()))
+	})
+
+	t.Run("ok - datatypes of values are not types", func(t *testing.T) {
+		doc := Document(`{
+  "@context": {"@vocab": "http://schema.org/", "xsd": "http://www.w3.org/2001/XMLSchema#"},
+  "@type": "Person",
+  "birthDate": {"@value": "1980-01-01", "@type": "xsd:date"}
+}`)
+
+		values, err := c.ValuesAtPath(doc, NewTermPath(DescendantTerm, TypeTerm))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, []Scalar{ScalarMustParse("http://schema.org/Person")}, values)
+	})
+
+	t.Run("ok - type is not followed by other terms", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdExample, NewTermPath(TypeTerm, "http://schema.org/name"))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 0)
+	})
+
+	t.Run("ok - follow a reverse property", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdReverseExample, NewTermPath(ReverseTerm("http://schema.org/worksFor")))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 2)
+		assert.Equal(t, "http://example.com/jane", string(values[0].Bytes()))
+		assert.Equal(t, "http://example.com/john", string(values[1].Bytes()))
+	})
+
+	t.Run("ok - follow a reverse property to a nested value", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdReverseExample, NewTermPath(ReverseTerm("http://schema.org/worksFor"), "http://schema.org/name"))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 2)
+		assert.Equal(t, "Jane Doe", string(values[0].Bytes()))
+	})
+
+	t.Run("ok - no reverse properties", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdExample, NewTermPath(ReverseTerm("http://schema.org/worksFor")))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 0)
+	})
+
+	t.Run("ok - wildcard without match", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdExample, NewTermPath(WildcardTerm, WildcardTerm, "http://schema.org/name"))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 0)
+	})
+}
+
+func TestCollection_ValuesAtPathGraph(t *testing.T) {
+	c := createCollection(testDB(t))
+	name := "http://schema.org/name"
+	graph := GraphTerm("http://example.com/employees")
+
+	t.Run("ok - only top-level nodes", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdGraphExample, NewTermPath(name))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 1)
+		assert.Equal(t, "Employees", string(values[0].Bytes()))
+	})
+
+	t.Run("ok - top-level nodes and nodes of named graphs", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdGraphExample, NewTermPath(AllGraphsTerm, name))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 3)
+		assert.Equal(t, "Employees", string(values[0].Bytes()))
+		assert.Equal(t, "Jane Doe", string(values[1].Bytes()))
+		assert.Equal(t, "John Doe", string(values[2].Bytes()))
+	})
+
+	t.Run("ok - root node by @id", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdGraphExample, NewTermPath(IDFilterTerm("http://example.com/credential"), TypeTerm))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 1)
+		assert.Equal(t, "http://schema.org/Dataset", string(values[0].Bytes()))
+	})
+
+	t.Run("ok - node of a named graph is not a root node", func(t *testing.T) {
+		jane := IDFilterTerm("http://example.com/jane")
+
+		values, _ := c.ValuesAtPath(jsonLdGraphExample, NewTermPath(jane, name))
+		assert.Len(t, values, 0)
+
+		values, _ = c.ValuesAtPath(jsonLdGraphExample, NewTermPath(AllGraphsTerm, jane, name))
+		if assert.Len(t, values, 1) {
+			assert.Equal(t, "Jane Doe", string(values[0].Bytes()))
+		}
+	})
+
+	t.Run("ok - nodes of a named graph", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdGraphExample, NewTermPath(graph, name))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 2)
+		assert.Equal(t, "Jane Doe", string(values[0].Bytes()))
+		assert.Equal(t, "John Doe", string(values[1].Bytes()))
+	})
+
+	t.Run("ok - graph term at the end of the path", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdGraphExample, NewTermPath(graph))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 1)
+		assert.Equal(t, "http://example.com/employees", string(values[0].Bytes()))
+	})
+
+	t.Run("ok - unknown graph", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdGraphExample, NewTermPath(GraphTerm("http://example.com/other"), name))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 0)
+	})
+
+	t.Run("ok - all graphs term is only allowed as first term", func(t *testing.T) {
+		values, err := c.ValuesAtPath(jsonLdGraphExample, NewTermPath(graph, AllGraphsTerm, name))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, values, 0)
+	})
+}
+
+func TestCollection_FindGraph(t *testing.T) {
+	ctx := context.Background()
+	c := createCollection(testDB(t))
+	_ = c.Add([]Document{jsonLdExample, jsonLdGraphExample})
+
+	t.Run("ok - by named graph", func(t *testing.T) {
+		docs, err := c.Find(ctx, New(InGraph("http://example.com/employees")))
+
+		assert.NoError(t, err)
+		assert.Len(t, docs, 1)
+	})
+
+	t.Run("ok - by value within a named graph", func(t *testing.T) {
+		termPath := NewTermPath(GraphTerm("http://example.com/employees"), "http://schema.org/name")
+
+		docs, err := c.Find(ctx, New(Eq(termPath, ScalarMustParse("Jane Doe"))))
+
+		assert.NoError(t, err)
+		assert.Len(t, docs, 1)
+	})
+
+	t.Run("ok - type of the root node", func(t *testing.T) {
+		_ = c.AddIndex(c.NewIndex("type", NewFieldIndexer(NewTermPath(TypeTerm))))
+
+		docs, err := c.Find(ctx, New(IsType("http://schema.org/Person")))
+
+		assert.NoError(t, err)
+		if assert.Len(t, docs, 1) {
+			assert.Equal(t, Document(jsonLdExample), docs[0])
+		}
+	})
+
+	t.Run("ok - indexed nodes of all graphs", func(t *testing.T) {
+		termPath := NewTermPath(AllGraphsTerm, TypeTerm)
+		_ = c.AddIndex(c.NewIndex("allTypes", NewFieldIndexer(termPath)))
+
+		docs, err := c.Find(ctx, New(Eq(termPath, ScalarMustParse("http://schema.org/Person"))))
+
+		assert.NoError(t, err)
+		assert.Len(t, docs, 2)
+	})
 }
 
 func TestNewIndex(t *testing.T) {
@@ -481,11 +1204,80 @@ func testIndex(t *testing.T, collection Collection) Index {
 	)
 }
 
+// externalIndex only implements the Index interface, like an index that is implemented outside this package
+type externalIndex struct {
+	Index
+}
+
 func createCollection(db *bbolt.DB) *collection {
 	return &collection{
-		Name:      "test",
-		db:        db,
-		IndexList: []Index{},
-		refMake:   defaultReferenceCreator,
+		Name:              "test",
+		db:                db,
+		IndexList:         []Index{},
+		refMake:           defaultReferenceCreator,
+		documentLoader:    testDocumentLoader,
+		documentProcessor: ld.NewJsonLdProcessor(),
 	}
 }
+
+func BenchmarkCollection_Add(b *testing.B) {
+	docs := make([]Document, 100)
+	for j := range docs {
+		docs[j] = []byte(fmt.Sprintf(`{"@context": ["http://schema.org/"], "@type": "Person", "identifier": "%d", "name": "Jane Doe %d", "children": [{"name": "John Doe"}]}`, j, j))
+	}
+	newCollection := func(b *testing.B, workers int) *collection {
+		db, err := bbolt.Open(filepath.Join(b.TempDir(), "bench.db"), boltDBFileMode, &bbolt.Options{NoSync: true})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() {
+			_ = db.Close()
+		})
+		c := createCollection(db)
+		c.workers = workers
+		_ = c.AddIndex(
+			c.NewIndex("name", NewFieldIndexer(NewTermPath("http://schema.org/name"))),
+			c.NewIndex("type", NewFieldIndexer(NewTermPath(TypeTerm)), NewFieldIndexer(NewTermPath("http://schema.org/identifier"))),
+			c.NewIndex("children", NewFieldIndexer(NewTermPath(DescendantTerm, "http://schema.org/name"))),
+		)
+		return c
+	}
+
+	// locked measures the time spent within the write transaction
+	run := func(b *testing.B, c *collection, prepare bool) {
+		var locked time.Duration
+		for n := 0; n < b.N; n++ {
+			var prepared []preparedDocument
+			if prepare {
+				var err error
+				if prepared, err = c.prepare(docs); err != nil {
+					b.Fatal(err)
+				}
+			}
+			start := time.Now()
+			err := c.db.Update(func(tx *bbolt.Tx) error {
+				if prepare {
+					return c.addPrepared(tx, prepared)
+				}
+				return c.add(tx, docs)
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			locked += time.Since(start)
+		}
+		b.ReportMetric(float64(locked.Nanoseconds())/float64(b.N), "locked-ns/op")
+	}
+
+	b.Run("processing within transaction", func(b *testing.B) {
+		run(b, newCollection(b, 1), false)
+	})
+
+	b.Run("sequential processing before transaction", func(b *testing.B) {
+		run(b, newCollection(b, 1), true)
+	})
+
+	b.Run("parallel processing before transaction", func(b *testing.B) {
+		run(b, newCollection(b, 0), true)
+	})
+}
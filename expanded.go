@@ -0,0 +1,309 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"go.etcd.io/bbolt"
+)
+
+// expandedBucket is the bucket next to the documentBucket that stores the encoded expanded form of the documents by reference
+const expandedBucket = "_expanded"
+
+// errInvalidEncoding is returned when a stored expanded document can't be decoded
+var errInvalidEncoding = errors.New("invalid expanded document encoding")
+
+// WithExpandedStorage stores the expanded form of every document in a compact binary encoding next to the original document.
+// Query filters that are not covered by an index, index builds and deletes use the stored form instead of expanding the document again.
+// It costs extra storage, and documents are expanded when they're added even if the collection doesn't have any indices.
+// See BenchmarkCollection_ExpandedStorage for the storage overhead and the speedup of queries.
+// Documents that were added without this option are expanded when needed.
+func WithExpandedStorage() CollectionOption {
+	return func(collection *collection) {
+		collection.storeExpanded = true
+	}
+}
+
+// expandedKeysIndex is implemented by indices that can derive the keys from an expanded document
+type expandedKeysIndex interface {
+	expandedKeys(expanded []interface{}) ([]Key, error)
+}
+
+// documentKeys uses the expanded document if given and supported by the index.
+// The index must implement documentKeysIndex.
+func documentKeys(i Index, doc Document, expanded []interface{}) ([]Key, error) {
+	if ei, ok := i.(expandedKeysIndex); ok && expanded != nil {
+		return ei.expandedKeys(expanded)
+	}
+	ki, ok := i.(documentKeysIndex)
+	if !ok {
+		return nil, fmt.Errorf("index %s doesn't return the keys of a document", i.Name())
+	}
+	return ki.DocumentKeys(doc)
+}
+
+// hasDocumentKeys returns true if the keys of a document can be derived for the index, see documentKeysIndex
+func hasDocumentKeys(i Index) bool {
+	_, ok := i.(documentKeysIndex)
+	return ok
+}
+
+// expand parses the document and returns the expanded JSON-LD form
+func (c *collection) expand(doc Document) ([]interface{}, error) {
+	var input interface{}
+	if err := json.Unmarshal(doc, &input); err != nil {
+		return nil, err
+	}
+
+	return c.documentProcessor.Expand(input, c.jsonLdOptions())
+}
+
+// storedExpanded returns the stored expanded form of the document or nil if it hasn't been stored
+func (c *collection) storedExpanded(tx *bbolt.Tx, ref Reference) ([]interface{}, error) {
+	bucket := c.bucket(tx)
+	if bucket == nil {
+		return nil, nil
+	}
+	eBucket := bucket.Bucket([]byte(expandedBucket))
+	if eBucket == nil {
+		return nil, nil
+	}
+	data := eBucket.Get(ref)
+	if data == nil {
+		return nil, nil
+	}
+	return decodeExpanded(data)
+}
+
+// expander returns a function that returns the stored expanded form of a document or expands the document
+func (c *collection) expander(tx *bbolt.Tx) func(ref Reference, doc Document) ([]interface{}, error) {
+	return func(ref Reference, doc Document) ([]interface{}, error) {
+		expanded, err := c.storedExpanded(tx, ref)
+		if err != nil || expanded != nil {
+			return expanded, err
+		}
+		return c.expand(doc)
+	}
+}
+
+// putExpanded stores the expanded form when configured.
+// Otherwise an existing entry is removed, since it may belong to a previous document with the same reference.
+func (c *collection) putExpanded(bucket *bbolt.Bucket, p preparedDocument) error {
+	if !c.storeExpanded {
+		return deleteExpanded(bucket, p.ref)
+	}
+
+	eBucket, err := bucket.CreateBucketIfNotExists([]byte(expandedBucket))
+	if err != nil {
+		return err
+	}
+	expanded := p.expanded
+	if expanded == nil {
+		if expanded, err = c.expand(p.doc); err != nil {
+			return err
+		}
+	}
+	data, err := encodeExpanded(expanded)
+	if err != nil {
+		return err
+	}
+	return eBucket.Put(p.ref, data)
+}
+
+func deleteExpanded(bucket *bbolt.Bucket, ref Reference) error {
+	if eBucket := bucket.Bucket([]byte(expandedBucket)); eBucket != nil {
+		return eBucket.Delete(ref)
+	}
+	return nil
+}
+
+// tags of the binary encoding of expanded documents.
+// Every value starts with a tag, strings, arrays and objects are followed by their length as uvarint.
+// Numbers are encoded as big endian float64 and object keys are sorted.
+const (
+	tagNull byte = iota
+	tagFalse
+	tagTrue
+	tagNumber
+	tagString
+	tagArray
+	tagObject
+)
+
+func encodeExpanded(expanded []interface{}) ([]byte, error) {
+	return appendValue(make([]byte, 0, 256), expanded)
+}
+
+func appendValue(buf []byte, value interface{}) ([]byte, error) {
+	var err error
+	switch v := value.(type) {
+	case nil:
+		buf = append(buf, tagNull)
+	case bool:
+		if v {
+			buf = append(buf, tagTrue)
+		} else {
+			buf = append(buf, tagFalse)
+		}
+	case float64:
+		buf = append(buf, tagNumber)
+		buf = appendFloat(buf, v)
+	case int:
+		buf = append(buf, tagNumber)
+		buf = appendFloat(buf, float64(v))
+	case int64:
+		buf = append(buf, tagNumber)
+		buf = appendFloat(buf, float64(v))
+	case string:
+		buf = append(buf, tagString)
+		buf = appendUvarint(buf, uint64(len(v)))
+		buf = append(buf, v...)
+	case []interface{}:
+		buf = append(buf, tagArray)
+		buf = appendUvarint(buf, uint64(len(v)))
+		for _, item := range v {
+			if buf, err = appendValue(buf, item); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf = append(buf, tagObject)
+		buf = appendUvarint(buf, uint64(len(v)))
+		for _, key := range keys {
+			buf = appendUvarint(buf, uint64(len(key)))
+			buf = append(buf, key...)
+			if buf, err = appendValue(buf, v[key]); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unable to encode value of type %T", value)
+	}
+	return buf, nil
+}
+
+func appendUvarint(buf []byte, n uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	size := binary.PutUvarint(b[:], n)
+	return append(buf, b[:size]...)
+}
+
+func appendFloat(buf []byte, f float64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], math.Float64bits(f))
+	return append(buf, b[:]...)
+}
+
+func decodeExpanded(data []byte) ([]interface{}, error) {
+	value, rest, err := readValue(data)
+	if err != nil {
+		return nil, err
+	}
+	expanded, ok := value.([]interface{})
+	if !ok || len(rest) != 0 {
+		return nil, errInvalidEncoding
+	}
+	return expanded, nil
+}
+
+func readValue(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errInvalidEncoding
+	}
+	tag, data := data[0], data[1:]
+
+	switch tag {
+	case tagNull:
+		return nil, data, nil
+	case tagFalse:
+		return false, data, nil
+	case tagTrue:
+		return true, data, nil
+	case tagNumber:
+		if len(data) < 8 {
+			return nil, nil, errInvalidEncoding
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+	case tagString:
+		s, rest, err := readString(data)
+		return s, rest, err
+	case tagArray:
+		n, data, err := readLength(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		array := make([]interface{}, n)
+		for j := range array {
+			if array[j], data, err = readValue(data); err != nil {
+				return nil, nil, err
+			}
+		}
+		return array, data, nil
+	case tagObject:
+		n, data, err := readLength(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		object := make(map[string]interface{}, n)
+		for j := 0; j < n; j++ {
+			var key string
+			if key, data, err = readString(data); err != nil {
+				return nil, nil, err
+			}
+			if object[key], data, err = readValue(data); err != nil {
+				return nil, nil, err
+			}
+		}
+		return object, data, nil
+	}
+	return nil, nil, errInvalidEncoding
+}
+
+func readString(data []byte) (string, []byte, error) {
+	n, data, err := readLength(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(data) < n {
+		return "", nil, errInvalidEncoding
+	}
+	return string(data[:n]), data[n:], nil
+}
+
+// readLength reads a uvarint length, it fails when the length exceeds the remaining data
+func readLength(data []byte) (int, []byte, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > uint64(len(data)) {
+		return 0, nil, errInvalidEncoding
+	}
+	return int(n), data[size:], nil
+}
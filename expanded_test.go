@@ -0,0 +1,227 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestEncodeExpanded(t *testing.T) {
+	t.Run("ok - round trip", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		expanded, _ := c.expand(jsonLdExample)
+
+		data, err := encodeExpanded(expanded)
+		if !assert.NoError(t, err) {
+			return
+		}
+		decoded, err := decodeExpanded(data)
+
+		assert.NoError(t, err)
+		assert.Equal(t, expanded, decoded)
+	})
+
+	t.Run("ok - all value types", func(t *testing.T) {
+		expanded := []interface{}{
+			map[string]interface{}{
+				"null":   nil,
+				"false":  false,
+				"true":   true,
+				"number": 1.5,
+				"string": "value",
+				"array":  []interface{}{},
+				"object": map[string]interface{}{},
+			},
+		}
+
+		data, _ := encodeExpanded(expanded)
+		decoded, err := decodeExpanded(data)
+
+		assert.NoError(t, err)
+		assert.Equal(t, expanded, decoded)
+	})
+
+	t.Run("error - unsupported type", func(t *testing.T) {
+		_, err := encodeExpanded([]interface{}{struct{}{}})
+
+		assert.Error(t, err)
+	})
+
+	t.Run("error - invalid data", func(t *testing.T) {
+		data, _ := encodeExpanded([]interface{}{map[string]interface{}{"key": "value"}})
+
+		for _, invalid := range [][]byte{nil, {tagString, 0}, {tagArray, 10}, {0xff}, data[:len(data)-1], append(data, 0)} {
+			_, err := decodeExpanded(invalid)
+			assert.Equal(t, errInvalidEncoding, err)
+		}
+	})
+}
+
+func TestWithExpandedStorage(t *testing.T) {
+	nameTermPath := NewTermPath("http://schema.org/name")
+	nameQuery := New(Eq(nameTermPath, ScalarMustParse("Jane Doe")))
+	expandedCount := func(t *testing.T, c *collection) int {
+		count := 0
+		_ = c.db.View(func(tx *bbolt.Tx) error {
+			if bucket := testBucket(t, tx).Bucket([]byte(expandedBucket)); bucket != nil {
+				count = bucket.Stats().KeyN
+			}
+			return nil
+		})
+		return count
+	}
+
+	t.Run("ok - stored and used by queries", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		c.storeExpanded = true
+
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+
+		assert.Equal(t, 2, expandedCount(t, c))
+		_ = c.db.View(func(tx *bbolt.Tx) error {
+			expanded, err := c.storedExpanded(tx, c.Reference(jsonLdExample))
+			assert.NoError(t, err)
+			assert.Equal(t, []Scalar{ScalarMustParse("Jane Doe")}, valuesFromSliceAtPath(expanded, nameTermPath))
+			return nil
+		})
+		docs, err := c.Find(context.Background(), nameQuery)
+		assert.NoError(t, err)
+		assert.Len(t, docs, 1)
+	})
+
+	t.Run("ok - used for indexing and deletes", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		c.storeExpanded = true
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		i := c.NewIndex("name", NewFieldIndexer(nameTermPath))
+
+		_ = c.AddIndex(i)
+		err := c.Delete(jsonLdExample)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, expandedCount(t, c))
+		docs, _ := c.Find(context.Background(), nameQuery)
+		assert.Len(t, docs, 0)
+		report, _ := c.Verify(context.Background())
+		assert.True(t, report.IsConsistent())
+	})
+
+	t.Run("ok - entry is removed when a document is added without the option", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		c.refMake = func(doc Document) Reference {
+			return Reference("constant")
+		}
+		c.storeExpanded = true
+		_ = c.Add([]Document{jsonLdExample})
+
+		c.storeExpanded = false
+		_ = c.Add([]Document{jsonLdExample2})
+
+		assert.Equal(t, 0, expandedCount(t, c))
+		docs, _ := c.Find(context.Background(), nameQuery)
+		assert.Len(t, docs, 0)
+	})
+
+	t.Run("ok - documents added before the option are expanded", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.Add([]Document{jsonLdExample})
+
+		c.storeExpanded = true
+		_ = c.Add([]Document{jsonLdExample2})
+
+		assert.Equal(t, 1, expandedCount(t, c))
+		docs, _ := c.Find(context.Background(), nameQuery)
+		assert.Len(t, docs, 1)
+	})
+
+	t.Run("ok - option", func(t *testing.T) {
+		c := testStore(t).Collection("test", WithExpandedStorage())
+
+		assert.True(t, c.(*collection).storeExpanded)
+	})
+}
+
+// BenchmarkCollection_ExpandedStorage compares adding and querying documents with and without the stored expanded form.
+// The query isn't covered by an index, so every document is filtered.
+// The storage metrics report the average size of the original and the expanded form.
+func BenchmarkCollection_ExpandedStorage(b *testing.B) {
+	docs := make([]Document, 100)
+	for j := range docs {
+		docs[j] = []byte(fmt.Sprintf(`{"@context": ["http://schema.org/"], "@type": "Person", "identifier": "%d", "name": "Jane Doe %d", "children": [{"name": "John Doe"}]}`, j, j))
+	}
+	query := New(Eq(NewTermPath("http://schema.org/name"), ScalarMustParse("Jane Doe 42")))
+	newCollection := func(b *testing.B, storeExpanded bool) *collection {
+		db, err := bbolt.Open(filepath.Join(b.TempDir(), "bench.db"), boltDBFileMode, &bbolt.Options{NoSync: true})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() {
+			_ = db.Close()
+		})
+		c := createCollection(db)
+		c.storeExpanded = storeExpanded
+		return c
+	}
+
+	for _, storeExpanded := range []bool{false, true} {
+		name := "original"
+		if storeExpanded {
+			name = "expanded"
+		}
+
+		b.Run(name+"/add", func(b *testing.B) {
+			c := newCollection(b, storeExpanded)
+
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				_ = c.Add(docs)
+			}
+		})
+
+		b.Run(name+"/find", func(b *testing.B) {
+			c := newCollection(b, storeExpanded)
+			_ = c.Add(docs)
+
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				_, _ = c.Find(context.Background(), query)
+			}
+			b.StopTimer()
+
+			var documentSize, expandedSize int
+			_ = c.db.View(func(tx *bbolt.Tx) error {
+				bucket := tx.Bucket([]byte(c.Name))
+				documentSize = bucket.Bucket(documentBucketByteRef()).Stats().LeafInuse
+				if eBucket := bucket.Bucket([]byte(expandedBucket)); eBucket != nil {
+					expandedSize = eBucket.Stats().LeafInuse
+				}
+				return nil
+			})
+			b.ReportMetric(float64(documentSize)/float64(len(docs)), "document-B/doc")
+			b.ReportMetric(float64(expandedSize)/float64(len(docs)), "expanded-B/doc")
+		})
+	}
+}
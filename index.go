@@ -63,6 +63,15 @@ type Index interface {
 	Keys(fi FieldIndexer, document Document) ([]Scalar, error)
 }
 
+// documentKeysIndex is implemented by indices that can return the keys of a document.
+// Their keys are derived before the write transaction and Verify checks their entries.
+// Other indices are updated through Add and Delete, Verify skips them.
+type documentKeysIndex interface {
+	// DocumentKeys returns all keys under which the document is indexed.
+	// A document without values for a part of the index is indexed with an empty value for that part.
+	DocumentKeys(doc Document) ([]Key, error)
+}
+
 // iteratorFn defines a function that is used as a callback when an IterateIndex query finds results. The function is called for each result entry.
 // the key will be the indexed value and the value will contain an Entry
 type iteratorFn DocumentWalker
@@ -86,12 +95,22 @@ func (i *index) Depth() int {
 }
 
 func (i *index) Keys(fi FieldIndexer, document Document) ([]Scalar, error) {
+	// the keys may only depend on the document itself, so no transaction is needed
+	if hasDereference(fi.TermPath()) {
+		return nil, ErrDereferenceIndex
+	}
+
 	// first get the raw values from the query path
 	rawKeys, err := i.collection.ValuesAtPath(document, fi.TermPath())
 	if err != nil {
 		return nil, err
 	}
 
+	return transformKeys(fi, rawKeys), nil
+}
+
+// transformKeys runs the tokenizer and transformer of the FieldIndexer
+func transformKeys(fi FieldIndexer, rawKeys []Scalar) []Scalar {
 	// run the tokenizer
 	tokenized := make([]Scalar, 0)
 	for _, rawKey := range rawKeys {
@@ -105,92 +124,101 @@ func (i *index) Keys(fi FieldIndexer, document Document) ([]Scalar, error) {
 		transformed[i] = fi.Transform(rawKey)
 	}
 
-	return transformed, nil
+	return transformed
 }
 
 func (i *index) Add(bucket *bbolt.Bucket, ref Reference, doc Document) error {
-	cBucket, _ := bucket.CreateBucketIfNotExists(i.BucketName())
-	return i.addDocumentR(cBucket, i.indexParts, Key{}, ref, doc)
-}
-
-// addDocumentR, like Add but recursive
-func (i *index) addDocumentR(bucket *bbolt.Bucket, parts []FieldIndexer, cKey Key, ref Reference, doc Document) error {
-	// current part
-	ip := parts[0]
-
-	matches, err := i.Keys(ip, doc)
+	cBucket, err := bucket.CreateBucketIfNotExists(i.BucketName())
 	if err != nil {
 		return err
 	}
 
-	// exit condition
-	if len(parts) == 1 {
-		// all matches to be added to current bucket
-		for _, m := range matches {
-			key := ComposeKey(cKey, m.Bytes())
-			_ = addRefToBucket(bucket, key, ref)
-		}
-		if len(matches) == 0 {
-			key := ComposeKey(cKey, Key{})
-			_ = addRefToBucket(bucket, key, ref)
-		}
-		return nil
+	keys, err := i.DocumentKeys(doc)
+	if err != nil {
+		return err
 	}
 
-	// continue recursion
-	for _, m := range matches {
-		nKey := ComposeKey(cKey, m.Bytes())
-		if err = i.addDocumentR(bucket, parts[1:], nKey, ref, doc); err != nil {
+	for _, key := range keys {
+		if err = addRefToBucket(cBucket, key, ref); err != nil {
 			return err
 		}
 	}
-
-	// no matches for the document and this part of the index
-	// add key with an empty byte slice as value
-	if len(matches) == 0 {
-		nKey := ComposeKey(cKey, Key{})
-		return i.addDocumentR(bucket, parts[1:], nKey, ref, doc)
-	}
-
 	return nil
 }
 
-// addDocumentR, like Add but recursive
-func (i *index) removeDocumentR(bucket *bbolt.Bucket, parts []FieldIndexer, cKey Key, ref Reference, doc Document) error {
-	// current part
-	ip := parts[0]
+func (i *index) Delete(bucket *bbolt.Bucket, ref Reference, doc Document) error {
+	cBucket := bucket.Bucket(i.BucketName())
+	if cBucket == nil {
+		return nil
+	}
 
-	matches, err := i.Keys(ip, doc)
+	keys, err := i.DocumentKeys(doc)
 	if err != nil {
 		return err
 	}
 
-	// exit condition
-	if len(parts) == 1 {
-		for _, m := range matches {
-			key := ComposeKey(cKey, m.Bytes())
-			_ = removeRefFromBucket(bucket, key, ref)
+	for _, key := range keys {
+		if err = removeRefFromBucket(cBucket, key, ref); err != nil {
+			return err
 		}
-		return nil
 	}
+	return nil
+}
 
-	// continue recursion
-	for _, m := range matches {
-		nKey := ComposeKey(cKey, m.Bytes())
-		return i.removeDocumentR(bucket, parts[1:], nKey, ref, doc)
-	}
+func (i *index) DocumentKeys(doc Document) ([]Key, error) {
+	return i.composeKeys(func(fi FieldIndexer) ([]Scalar, error) {
+		return i.Keys(fi, doc)
+	})
+}
 
-	// no matches for the document and this part of the index
-	return nil
+// expandedKeys is like DocumentKeys, but uses the expanded form of the document
+func (i *index) expandedKeys(expanded []interface{}) ([]Key, error) {
+	return i.composeKeys(func(fi FieldIndexer) ([]Scalar, error) {
+		if fi.TermPath().IsEmpty() {
+			return []Scalar{}, nil
+		}
+		if hasDereference(fi.TermPath()) {
+			return nil, ErrDereferenceIndex
+		}
+		return transformKeys(fi, valuesFromDocumentAtPath(expanded, fi.TermPath())), nil
+	})
 }
 
-func (i *index) Delete(bucket *bbolt.Bucket, ref Reference, doc Document) error {
-	cBucket := bucket.Bucket(i.BucketName())
-	if cBucket == nil {
-		return nil
+// composeKeys creates the keys from the values of every part
+func (i *index) composeKeys(partKeys func(fi FieldIndexer) ([]Scalar, error)) ([]Key, error) {
+	if len(i.indexParts) == 0 {
+		return []Key{}, nil
 	}
 
-	return i.removeDocumentR(cBucket, i.indexParts, Key{}, ref, doc)
+	// start with a single empty key and extend it with all values of every part
+	keys := []Key{{}}
+	for _, ip := range i.indexParts {
+		matches, err := partKeys(ip)
+		if err != nil {
+			return nil, err
+		}
+
+		// no matches for the document and this part of the index
+		// the key is extended with an empty byte slice as value
+		if len(matches) == 0 {
+			matches = []Scalar{{}}
+		}
+
+		nKeys := make([]Key, 0, len(keys)*len(matches))
+		seen := map[string]bool{}
+		for _, cKey := range keys {
+			for _, m := range matches {
+				nKey := ComposeKey(cKey, m.Bytes())
+				if !seen[string(nKey)] {
+					seen[string(nKey)] = true
+					nKeys = append(nKeys, nKey)
+				}
+			}
+		}
+		keys = nKeys
+	}
+
+	return keys, nil
 }
 
 // addRefToBucket adds the reference to the correct key in the bucket. It handles multiple reference on the same location
@@ -203,14 +231,21 @@ func addRefToBucket(bucket *bbolt.Bucket, key Key, ref Reference) error {
 	return subBucket.Put(ref, []byte{})
 }
 
-// removeRefFromBucket removes the reference from the bucket. It handles multiple reference on the same location
+// removeRefFromBucket removes the reference from the bucket. It handles multiple reference on the same location.
+// The sub-bucket for the key is removed when it no longer contains any references.
 func removeRefFromBucket(bucket *bbolt.Bucket, key Key, ref Reference) error {
 	// first check if there's a sub-bucket
 	subBucket := bucket.Bucket(key)
 	if subBucket == nil {
 		return nil
 	}
-	return subBucket.Delete(ref)
+	if err := subBucket.Delete(ref); err != nil {
+		return err
+	}
+	if k, _ := subBucket.Cursor().First(); k == nil {
+		return bucket.DeleteBucket(key)
+	}
+	return nil
 }
 
 func (i *index) IsMatch(query Query) float64 {
@@ -336,16 +371,21 @@ func findR(cursor *bbolt.Cursor, sKey Key, matchers []matcher, fn iteratorFn) er
 		seek := ComposeKey(sKey, seekTerm.Bytes())
 		condition := true
 		for cKey, _ := cursor.Seek(seek); cKey != nil && bytes.HasPrefix(cKey, sKey) && condition; cKey, _ = cursor.Next() {
-			// remove prefix (+1), Split and take first
-			pf := cKey[len(sKey)+1:]
-			if len(sKey) == 0 {
-				pf = cKey
+			// remove prefix, Split and take first
+			pfk := Key(cKey[len(sKey):])
+			split := pfk.Split()
+			if len(split) == 0 {
+				continue
 			}
-			pfk := Key(pf)
-			newp := pfk.Split()[0] // todo bounds check?
+			newp := split[0]
 
 			// check of current (partial) key still matches with query
 			condition = cPart.Condition(newp, matchers[0].transform)
+			if r, ok := cPart.(rangeEnd); ok && !condition && !r.pastEnd(newp, matchers[0].transform) {
+				// following keys may still match
+				condition = true
+				continue
+			}
 			if condition {
 				if len(matchers) > 1 {
 					// (partial) key still matches, continue to next index part
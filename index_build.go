@@ -0,0 +1,340 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrIndexDropped is returned by IndexBuild.Wait when the index was dropped during the build
+var ErrIndexDropped = errors.New("index dropped during build")
+
+// indexBuildBucket is the bucket within a collection bucket that marks indices that are still being built.
+// An index bucket for which a marker exists is incomplete and is rebuilt when the index is added again.
+// The value of a marker is the ID of the build, so a build only removes its own partial index.
+const indexBuildBucket = "_building"
+
+// defaultIndexBatchSize is the number of documents indexed per transaction when building an index
+const defaultIndexBatchSize = 1000
+
+// IndexBuild tracks the progress of an index that is built in the background.
+// The index is only used by queries once the build has completed.
+type IndexBuild struct {
+	done      chan struct{}
+	err       error
+	processed int64
+	total     int64
+	cancel    context.CancelFunc
+	index     Index
+	// id is stored in the marker of the build, it's unique within the collection
+	id []byte
+}
+
+func newIndexBuild(index Index) *IndexBuild {
+	return &IndexBuild{
+		done:   make(chan struct{}),
+		index:  index,
+		cancel: func() {},
+	}
+}
+
+// Done returns a channel that is closed when the build has finished, successful or not.
+func (b *IndexBuild) Done() <-chan struct{} {
+	return b.done
+}
+
+// Err returns the error of a finished build. It returns nil if the build is still running or if it was successful.
+func (b *IndexBuild) Err() error {
+	select {
+	case <-b.done:
+		return b.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the build has finished and returns its error.
+// It returns the context error if the given context is done first, the build itself continues.
+func (b *IndexBuild) Wait(ctx context.Context) error {
+	select {
+	case <-b.done:
+		return b.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Progress returns the number of processed documents and the number of documents at the start of the build.
+// Documents added during the build are indexed by the write itself and are not counted.
+func (b *IndexBuild) Progress() (processed int, total int) {
+	return int(atomic.LoadInt64(&b.processed)), int(atomic.LoadInt64(&b.total))
+}
+
+func (b *IndexBuild) finish(err error) {
+	b.err = err
+	b.cancel()
+	close(b.done)
+}
+
+func (c *collection) BuildIndex(ctx context.Context, index Index) *IndexBuild {
+	build := newIndexBuild(index)
+	if internalBuckets[string(index.BucketName())] {
+		build.finish(fmt.Errorf("%w: %s", ErrReservedName, index.Name()))
+		return build
+	}
+	if err := checkIndexable(index); err != nil {
+		build.finish(err)
+		return build
+	}
+	// the cancel func must be available before the build is registered, DropIndex may use it
+	buildCtx, cancel := context.WithCancel(ctx)
+	build.cancel = cancel
+
+	var running *IndexBuild
+	var exists bool
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		running, exists, err = c.registerBuild(tx, build)
+		return err
+	})
+
+	switch {
+	case err != nil:
+		build.finish(err)
+		return build
+	case running != nil:
+		cancel()
+		return running
+	case exists:
+		build.finish(nil)
+		return build
+	}
+
+	go c.runBuild(buildCtx, build)
+
+	return build
+}
+
+// registerBuild checks if the index already exists or is being built.
+// If not, the build is registered so concurrent writes are applied to the new index as well.
+func (c *collection) registerBuild(tx *bbolt.Tx, build *IndexBuild) (*IndexBuild, bool, error) {
+	index := build.index
+
+	c.indexMutex.Lock()
+	defer c.indexMutex.Unlock()
+
+	for _, i := range c.IndexList {
+		if i.Name() == index.Name() {
+			return nil, true, nil
+		}
+	}
+	if running, ok := c.builds[index.Name()]; ok {
+		return running, false, nil
+	}
+
+	bucket, err := c.createBucket(tx)
+	if err != nil {
+		return nil, false, err
+	}
+	markers, err := bucket.CreateBucketIfNotExists([]byte(indexBuildBucket))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if bucket.Bucket(index.BucketName()) != nil {
+		if markers.Get(index.BucketName()) == nil {
+			// complete index from a previous run
+			c.IndexList = append(c.IndexList, index)
+			return nil, true, nil
+		}
+		// incomplete index from a previous run
+		if err = bucket.DeleteBucket(index.BucketName()); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if _, err = bucket.CreateBucket(index.BucketName()); err != nil {
+		return nil, false, err
+	}
+	sequence, err := markers.NextSequence()
+	if err != nil {
+		return nil, false, err
+	}
+	build.id = make([]byte, 8)
+	binary.BigEndian.PutUint64(build.id, sequence)
+	if err = markers.Put(index.BucketName(), build.id); err != nil {
+		return nil, false, err
+	}
+	if docBucket := bucket.Bucket(documentBucketByteRef()); docBucket != nil {
+		build.total = int64(docBucket.Stats().KeyN)
+	}
+
+	if c.builds == nil {
+		c.builds = map[string]*IndexBuild{}
+	}
+	c.builds[index.Name()] = build
+
+	return nil, false, nil
+}
+
+// runBuild indexes the documents in batches, each batch uses its own transaction.
+func (c *collection) runBuild(ctx context.Context, build *IndexBuild) {
+	var last Reference
+	for {
+		var finished bool
+		err := c.db.Update(func(tx *bbolt.Tx) error {
+			// checked within the transaction so a DropIndex can't interleave
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var err error
+			last, finished, err = c.buildBatch(tx, build, last)
+			return err
+		})
+		if err != nil {
+			c.abortBuild(build, err)
+			return
+		}
+		if finished {
+			build.finish(nil)
+			return
+		}
+	}
+}
+
+// buildBatch indexes the next batch of documents after the last reference.
+// When all documents have been indexed, the index is made available to the query planner.
+func (c *collection) buildBatch(tx *bbolt.Tx, build *IndexBuild, last Reference) (Reference, bool, error) {
+	bucket := c.bucket(tx)
+	if !ownsIndexBucket(bucket, build) {
+		return nil, false, ErrIndexDropped
+	}
+
+	batchSize := c.batchSize
+	if batchSize <= 0 {
+		batchSize = defaultIndexBatchSize
+	}
+
+	count := 0
+	if docBucket := bucket.Bucket(documentBucketByteRef()); docBucket != nil {
+		cursor := docBucket.Cursor()
+		ref, doc := cursor.First()
+		if last != nil {
+			ref, doc = cursor.Seek(last)
+			if ref != nil && bytes.Equal(ref, last) {
+				ref, doc = cursor.Next()
+			}
+		}
+		for ; ref != nil; ref, doc = cursor.Next() {
+			if count == batchSize {
+				atomic.AddInt64(&build.processed, int64(count))
+				return append(Reference{}, last...), false, nil
+			}
+			if err := c.indexDocument(tx, bucket, build.index, ref, doc); err != nil {
+				return nil, false, err
+			}
+			last = ref
+			count++
+		}
+	}
+	atomic.AddInt64(&build.processed, int64(count))
+
+	// all documents are indexed, concurrent writes have been applied by the writers
+	if err := bucket.Bucket([]byte(indexBuildBucket)).Delete(build.index.BucketName()); err != nil {
+		return nil, false, err
+	}
+
+	c.indexMutex.Lock()
+	defer c.indexMutex.Unlock()
+	delete(c.builds, build.index.Name())
+	c.IndexList = append(c.IndexList, build.index)
+
+	return nil, true, nil
+}
+
+// indexDocument adds the document to the index, the stored expanded form is used when available
+func (c *collection) indexDocument(tx *bbolt.Tx, bucket *bbolt.Bucket, i Index, ref Reference, doc Document) error {
+	if !hasDocumentKeys(i) {
+		return i.Add(bucket, ref, doc)
+	}
+	expanded, err := c.storedExpanded(tx, ref)
+	if err != nil {
+		return err
+	}
+	keys, err := documentKeys(i, doc, expanded)
+	if err != nil {
+		return err
+	}
+	iBucket, err := bucket.CreateBucketIfNotExists(i.BucketName())
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = addRefToBucket(iBucket, key, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownsIndexBucket returns true if the index bucket exists and has been created by the build
+func ownsIndexBucket(bucket *bbolt.Bucket, build *IndexBuild) bool {
+	if bucket == nil || bucket.Bucket(build.index.BucketName()) == nil {
+		return false
+	}
+	markers := bucket.Bucket([]byte(indexBuildBucket))
+	return markers != nil && bytes.Equal(markers.Get(build.index.BucketName()), build.id)
+}
+
+// abortBuild removes the partial index if it hasn't been replaced by another build
+func (c *collection) abortBuild(build *IndexBuild, cause error) {
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		c.indexMutex.Lock()
+		defer c.indexMutex.Unlock()
+		if c.builds[build.index.Name()] == build {
+			delete(c.builds, build.index.Name())
+		} else if errors.Is(cause, context.Canceled) {
+			// DropIndex removed the build
+			cause = ErrIndexDropped
+		}
+
+		// after DropIndex, a new build of an index with the same name may have replaced the partial index
+		bucket := c.bucket(tx)
+		if !ownsIndexBucket(bucket, build) {
+			return nil
+		}
+		if err := bucket.DeleteBucket(build.index.BucketName()); err != nil {
+			return err
+		}
+		return bucket.Bucket([]byte(indexBuildBucket)).Delete(build.index.BucketName())
+	})
+	if err == nil {
+		err = cause
+	}
+	build.finish(err)
+}
@@ -0,0 +1,227 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestCollection_BuildIndex(t *testing.T) {
+	identifierTermPath := NewTermPath("http://schema.org/identifier")
+	testDocs := func(n int) []Document {
+		docs := make([]Document, n)
+		for j := range docs {
+			docs[j] = []byte(fmt.Sprintf(`{"@context": ["http://schema.org/"], "identifier": "%d"}`, j))
+		}
+		return docs
+	}
+
+	t.Run("ok - built in batches", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		c.batchSize = 2
+		_ = c.Add(testDocs(5))
+		i := c.NewIndex(t.Name(), NewFieldIndexer(identifierTermPath))
+
+		build := c.BuildIndex(context.Background(), i)
+		err := build.Wait(context.Background())
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		processed, total := build.Progress()
+		assert.Equal(t, 5, processed)
+		assert.Equal(t, 5, total)
+		assert.Len(t, c.IndexList, 1)
+		assertIndexSize(t, db, i, 5)
+		assert.Equal(t, i, c.findIndex(New(Eq(identifierTermPath, ScalarMustParse("1")))))
+	})
+
+	t.Run("ok - existing index", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := c.NewIndex(t.Name(), NewFieldIndexer(identifierTermPath))
+		_ = c.AddIndex(i)
+
+		build := c.BuildIndex(context.Background(), i)
+
+		assert.NoError(t, build.Wait(context.Background()))
+		assert.Len(t, c.IndexList, 1)
+	})
+
+	t.Run("ok - index is not used by queries during the build", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.Add(testDocs(2))
+		i := c.NewIndex(t.Name(), NewFieldIndexer(identifierTermPath))
+		build := newIndexBuild(i)
+
+		_ = db.Update(func(tx *bbolt.Tx) error {
+			_, _, err := c.registerBuild(tx, build)
+			return err
+		})
+
+		assert.Nil(t, c.findIndex(New(Eq(identifierTermPath, ScalarMustParse("1")))))
+		assert.Len(t, c.writeIndices(), 1)
+
+		c.runBuild(context.Background(), build)
+
+		assert.NoError(t, build.Err())
+		assert.Equal(t, i, c.findIndex(New(Eq(identifierTermPath, ScalarMustParse("1")))))
+		assert.Len(t, c.writeIndices(), 1)
+	})
+
+	t.Run("ok - writes during the build are captured", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		c.batchSize = 1
+		docs := testDocs(40)
+		_ = c.Add(docs[:20])
+		i := c.NewIndex(t.Name(), NewFieldIndexer(identifierTermPath))
+
+		build := c.BuildIndex(context.Background(), i)
+		wg := sync.WaitGroup{}
+		for _, doc := range docs[20:] {
+			wg.Add(1)
+			go func(doc Document) {
+				defer wg.Done()
+				_ = c.Add([]Document{doc})
+			}(doc)
+		}
+		for _, doc := range docs[:5] {
+			wg.Add(1)
+			go func(doc Document) {
+				defer wg.Done()
+				_ = c.Delete(doc)
+			}(doc)
+		}
+		wg.Wait()
+
+		if !assert.NoError(t, build.Wait(context.Background())) {
+			return
+		}
+		assertIndexSize(t, db, i, 35)
+		report, _ := c.Verify(context.Background())
+		assert.True(t, report.IsConsistent())
+	})
+
+	t.Run("ok - incomplete index from a previous run is rebuilt", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.Add(testDocs(2))
+		i := c.NewIndex(t.Name(), NewFieldIndexer(identifierTermPath))
+		_ = withinBucket(t, db, func(bucket *bbolt.Bucket) error {
+			iBucket, _ := bucket.CreateBucket(i.BucketName())
+			_ = addRefToBucket(iBucket, ComposeKey(nil, Key("0")), Reference("stale"))
+			markers, _ := bucket.CreateBucketIfNotExists([]byte(indexBuildBucket))
+			return markers.Put(i.BucketName(), []byte{})
+		})
+
+		err := c.AddIndex(i)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assertIndexSize(t, db, i, 2)
+		_ = db.View(func(tx *bbolt.Tx) error {
+			assert.Nil(t, testBucket(t, tx).Bucket([]byte(indexBuildBucket)).Get(i.BucketName()))
+			return nil
+		})
+	})
+
+	t.Run("error - cancelled", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.Add(testDocs(2))
+		i := c.NewIndex(t.Name(), NewFieldIndexer(identifierTermPath))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := c.BuildIndex(ctx, i).Wait(context.Background())
+
+		assert.Equal(t, context.Canceled, err)
+		assert.Len(t, c.IndexList, 0)
+		assert.Len(t, c.writeIndices(), 0)
+		_ = db.View(func(tx *bbolt.Tx) error {
+			assert.Nil(t, testBucket(t, tx).Bucket(i.BucketName()))
+			return nil
+		})
+	})
+
+	t.Run("error - dropped during the build", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.Add(testDocs(2))
+		i := c.NewIndex(t.Name(), NewFieldIndexer(identifierTermPath))
+		ctx, cancel := context.WithCancel(context.Background())
+		build := newIndexBuild(i)
+		build.cancel = cancel
+		_ = db.Update(func(tx *bbolt.Tx) error {
+			_, _, err := c.registerBuild(tx, build)
+			return err
+		})
+
+		_ = c.DropIndex(i.Name())
+		c.runBuild(ctx, build)
+
+		assert.Equal(t, ErrIndexDropped, build.Err())
+		assert.Len(t, c.writeIndices(), 0)
+	})
+
+	t.Run("error - dropped and added again during the build", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.Add(testDocs(2))
+		i := c.NewIndex(t.Name(), NewFieldIndexer(identifierTermPath))
+		ctx, cancel := context.WithCancel(context.Background())
+		stale := newIndexBuild(i)
+		stale.cancel = cancel
+		_ = db.Update(func(tx *bbolt.Tx) error {
+			_, _, err := c.registerBuild(tx, stale)
+			return err
+		})
+
+		_ = c.DropIndex(i.Name())
+		err := c.AddIndex(c.NewIndex(t.Name(), NewFieldIndexer(identifierTermPath)))
+		c.runBuild(ctx, stale)
+
+		assert.NoError(t, err)
+		assert.Equal(t, ErrIndexDropped, stale.Err())
+		if assert.Len(t, c.indices(), 1) {
+			assertIndexSize(t, db, c.indices()[0], 2)
+		}
+	})
+
+	t.Run("error - wait with cancelled context", func(t *testing.T) {
+		build := newIndexBuild(nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := build.Wait(ctx)
+
+		assert.Equal(t, context.Canceled, err)
+	})
+}
@@ -44,7 +44,7 @@ func TestIndex_AddJson(t *testing.T) {
 			return i.Add(testBucket(t, tx), ref, doc)
 		})
 
-		assertIndexed(t, db, i, []byte("Jane Doe"), ref)
+		assertIndexed(t, db, i, ComposeKey(nil, Key("Jane Doe")), ref)
 	})
 
 	t.Run("ok - values added as key to document reference", func(t *testing.T) {
@@ -57,7 +57,7 @@ func TestIndex_AddJson(t *testing.T) {
 			return i.Add(testBucket(t, tx), ref, doc)
 		})
 
-		assertIndexed(t, db, i, ComposeKey(Key("http://www.janedoe.com"), Key("Jane Doe")), ref)
+		assertIndexed(t, db, i, ComposeKey(ComposeKey(nil, Key("http://www.janedoe.com")), Key("Jane Doe")), ref)
 	})
 
 	t.Run("ok - value added as key using recursion", func(t *testing.T) {
@@ -70,11 +70,35 @@ func TestIndex_AddJson(t *testing.T) {
 			return i.Add(testBucket(t, tx), ref, doc)
 		})
 
-		key := ComposeKey(Key("Jane Doe"), Key("John Doe"))
+		key := ComposeKey(ComposeKey(nil, Key("Jane Doe")), Key("John Doe"))
 
 		assertIndexed(t, db, i, key, ref)
 	})
 
+	t.Run("ok - all values of a descendant path are added", func(t *testing.T) {
+		i := c.NewIndex(t.Name(), NewFieldIndexer(NewTermPath(DescendantTerm, "http://schema.org/name")))
+
+		_ = db.Update(func(tx *bbolt.Tx) error {
+			return i.Add(testBucket(t, tx), ref, doc)
+		})
+
+		assertIndexed(t, db, i, ComposeKey(nil, Key("Jane Doe")), ref)
+		assertIndexed(t, db, i, ComposeKey(nil, Key("John Doe")), ref)
+		assertIndexSize(t, db, i, 2)
+	})
+
+	t.Run("ok - only values of typed nodes are added", func(t *testing.T) {
+		typedRef := defaultReferenceCreator(jsonLdTypedExample)
+		i := c.NewIndex(t.Name(), NewFieldIndexer(NewTermPath(WildcardTerm, TypeFilterTerm("http://schema.org/Organization"), "http://schema.org/name")))
+
+		_ = db.Update(func(tx *bbolt.Tx) error {
+			return i.Add(testBucket(t, tx), typedRef, jsonLdTypedExample)
+		})
+
+		assertIndexed(t, db, i, ComposeKey(nil, Key("Nuts")), typedRef)
+		assertIndexSize(t, db, i, 1)
+	})
+
 	t.Run("ok - multiple entries", func(t *testing.T) {
 		i := c.NewIndex(t.Name(), NewFieldIndexer(NewTermPath("http://schema.org/telephone")))
 
@@ -84,7 +108,7 @@ func TestIndex_AddJson(t *testing.T) {
 			return i.Add(b, ref2, doc2)
 		})
 
-		key := Key("(425) 123-4567")
+		key := ComposeKey(nil, Key("(425) 123-4567"))
 
 		// check if both docs are indexed
 		assertIndexed(t, db, i, key, ref)
@@ -119,7 +143,7 @@ func TestIndex_AddJson(t *testing.T) {
 			return i.Add(testBucket(t, tx), ref2, doc2)
 		})
 
-		key := ComposeKey(Key("Jane Doe"), []byte{})
+		key := ComposeKey(ComposeKey(nil, Key("Jane Doe")), []byte{})
 
 		assertIndexed(t, db, i, key, ref)
 		assertIndexSize(t, db, i, 2)
@@ -202,10 +226,43 @@ func TestIndex_Delete(t *testing.T) {
 			return
 		}
 
-		key := ComposeKey(Key("John Doe"), Key{})
+		key := ComposeKey(ComposeKey(nil, Key("John Doe")), Key{})
 
 		assertIndexed(t, db, i, key, ref2)
 	})
+
+	t.Run("ok - entries for missing values are removed", func(t *testing.T) {
+		i := c.NewIndex(t.Name(),
+			NewFieldIndexer(nameTermPath),
+			NewFieldIndexer(NewTermPath("http://schema.org/children", "http://schema.org/name")),
+			NewFieldIndexer(NewTermPath("http://schema.org/image")),
+		)
+
+		_ = db.Update(func(tx *bbolt.Tx) error {
+			b := testBucket(t, tx)
+			_ = i.Add(b, ref, doc)
+			_ = i.Add(b, ref2, doc2)
+			_ = i.Delete(b, ref, doc)
+			return i.Delete(b, ref2, doc2)
+		})
+
+		assertIndexEmpty(t, db, i)
+	})
+
+	t.Run("ok - all matches are removed", func(t *testing.T) {
+		i := c.NewIndex(t.Name(),
+			NewFieldIndexer(NewTermPath(DescendantTerm, "http://schema.org/name")),
+			NewFieldIndexer(nameTermPath),
+		)
+
+		_ = db.Update(func(tx *bbolt.Tx) error {
+			b := testBucket(t, tx)
+			_ = i.Add(b, ref, doc)
+			return i.Delete(b, ref, doc)
+		})
+
+		assertIndexEmpty(t, db, i)
+	})
 }
 
 func TestIndex_IsMatch(t *testing.T) {
@@ -0,0 +1,176 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrInvalidJoin is returned when the Left side of a Join doesn't have a TermPath
+var ErrInvalidJoin = errors.New("join requires a TermPath on the left side")
+
+// ErrUnknownCollection is returned by Join when a collection hasn't been opened with Store.Collection
+var ErrUnknownCollection = errors.New("unknown collection")
+
+// JoinSide selects the documents of a collection that take part in a Join and the values they're joined on.
+type JoinSide struct {
+	// Collection is the name of the collection, it must have been opened with Store.Collection
+	Collection string
+	// Query selects the documents, all documents of the collection take part when it's nil
+	Query Query
+	// TermPath selects the values that are compared with the values of the other side
+	TermPath TermPath
+}
+
+// Join matches the documents of the Left side with the documents of the Right side.
+// A pair is formed when a value at the Left TermPath equals a value at the Right TermPath.
+// Typically, the Left TermPath selects an IRI reference and the Right TermPath is empty, which defaults to the @id of the nodes.
+//
+// The side with a query drives the join, the right side when both or neither have one.
+// The documents of the driving side are found with its query, the other side is queried for every distinct value.
+// Both queries are planned as usual, so an index on the TermPath of the other side is used for the lookups.
+type Join struct {
+	Left  JoinSide
+	Right JoinSide
+}
+
+// DocumentPair is a result of a Join
+type DocumentPair struct {
+	Left  Document
+	Right Document
+}
+
+// storedDocument is a document with the reference it's stored under
+type storedDocument struct {
+	ref Reference
+	doc Document
+}
+
+// Join returns the pairs of documents that match the join within a single read transaction.
+// A document is part of as many pairs as it has matching documents on the other side.
+func (s *store) Join(ctx context.Context, join Join) ([]DocumentPair, error) {
+	if join.Left.TermPath.IsEmpty() {
+		return nil, ErrInvalidJoin
+	}
+	if join.Right.TermPath.IsEmpty() {
+		join.Right.TermPath = NewTermPath(IDTerm)
+	}
+
+	driver, probe := join.Right, join.Left
+	swapped := join.Right.Query == nil && join.Left.Query != nil
+	if swapped {
+		driver, probe = probe, driver
+	}
+	// the collections are looked up, so the options they were opened with are used
+	driverCollection, ok := s.collections[driver.Collection]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCollection, driver.Collection)
+	}
+	probeCollection, ok := s.collections[probe.Collection]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCollection, probe.Collection)
+	}
+
+	var pairs []DocumentPair
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		drivers, err := driverCollection.collect(ctx, tx, driver.Query)
+		if err != nil {
+			return err
+		}
+
+		expand := driverCollection.expander(tx)
+		evaluate := driverCollection.pathEvaluator(tx)
+		// the matches of the probe side by value
+		matches := map[string][]storedDocument{}
+		pairs = make([]DocumentPair, 0)
+		for _, d := range drivers {
+			expanded, err := expand(d.ref, d.doc)
+			if err != nil {
+				return err
+			}
+			values, err := evaluate(expanded, driver.TermPath)
+			if err != nil {
+				return err
+			}
+
+			// a document is paired once with every matching document
+			paired := map[string]bool{}
+			for _, value := range values {
+				key := string(value.Bytes())
+				others, ok := matches[key]
+				if !ok {
+					if others, err = probeCollection.collect(ctx, tx, probeQuery(probe, value)); err != nil {
+						return err
+					}
+					matches[key] = others
+				}
+				for _, other := range others {
+					if paired[string(other.ref)] {
+						continue
+					}
+					paired[string(other.ref)] = true
+					if swapped {
+						pairs = append(pairs, DocumentPair{Left: d.doc, Right: other.doc})
+					} else {
+						pairs = append(pairs, DocumentPair{Left: other.doc, Right: d.doc})
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// probeQuery returns the query for the documents of the side with the given value at its TermPath
+func probeQuery(side JoinSide, value Scalar) Query {
+	q := New(Eq(side.TermPath, value))
+	if side.Query != nil {
+		for _, part := range side.Query.Parts() {
+			q = q.And(part)
+		}
+	}
+	return q
+}
+
+// collect returns the documents that match the query, or all documents when the query is nil
+func (c *collection) collect(ctx context.Context, tx *bbolt.Tx, query Query) ([]storedDocument, error) {
+	docs := make([]storedDocument, 0)
+	walker := func(key Reference, value []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// the key and value are only valid during the transaction
+		docs = append(docs, storedDocument{ref: append(Reference{}, key...), doc: append(Document{}, value...)})
+		return nil
+	}
+
+	if err := c.iterateOrScan(tx, query, walker); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
@@ -0,0 +1,161 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var joinJane = []byte(`{"@context": ["http://schema.org/"], "@type": "Person", "name": "Jane Doe", "worksFor": {"@id": "http://example.com/nuts"}}`)
+var joinJohn = []byte(`{"@context": ["http://schema.org/"], "@type": "Person", "name": "John Doe", "worksFor": {"@id": "http://example.com/nuts"}}`)
+var joinJack = []byte(`{"@context": ["http://schema.org/"], "@type": "Person", "name": "Jack Doe", "worksFor": {"@id": "http://example.com/unknown"}}`)
+var joinNuts = []byte(`{"@context": ["http://schema.org/"], "@id": "http://example.com/nuts", "@type": "Organization", "name": "Nuts"}`)
+var joinOther = []byte(`{"@context": ["http://schema.org/"], "@id": "http://example.com/other", "@type": "Organization", "name": "Other"}`)
+
+func TestStore_Join(t *testing.T) {
+	ctx := context.Background()
+	worksFor := NewTermPath("http://schema.org/worksFor")
+	name := NewTermPath("http://schema.org/name")
+	joinStore := func(t *testing.T) Store {
+		s := testStore(t)
+		people := s.Collection("people")
+		_ = people.AddIndex(people.NewIndex("worksFor", NewFieldIndexer(worksFor)))
+		_ = people.Add([]Document{joinJane, joinJohn, joinJack})
+		organizations := s.Collection("organizations")
+		_ = organizations.AddIndex(organizations.NewIndex("name", NewFieldIndexer(name)))
+		_ = organizations.Add([]Document{joinNuts, joinOther})
+		return s
+	}
+
+	t.Run("ok - references to the @id", func(t *testing.T) {
+		s := joinStore(t)
+
+		pairs, err := s.Join(ctx, Join{
+			Left:  JoinSide{Collection: "people", TermPath: worksFor},
+			Right: JoinSide{Collection: "organizations"},
+		})
+
+		if !assert.NoError(t, err) || !assert.Len(t, pairs, 2) {
+			return
+		}
+		for _, pair := range pairs {
+			assert.Equal(t, Document(joinNuts), pair.Right)
+		}
+		assert.ElementsMatch(t, []Document{joinJane, joinJohn}, []Document{pairs[0].Left, pairs[1].Left})
+	})
+
+	t.Run("ok - driven by the right query", func(t *testing.T) {
+		s := joinStore(t)
+
+		pairs, err := s.Join(ctx, Join{
+			Left:  JoinSide{Collection: "people", TermPath: worksFor},
+			Right: JoinSide{Collection: "organizations", Query: New(Eq(name, ScalarMustParse("Other")))},
+		})
+
+		assert.NoError(t, err)
+		assert.Len(t, pairs, 0)
+	})
+
+	t.Run("ok - driven by the left query", func(t *testing.T) {
+		s := joinStore(t)
+
+		pairs, err := s.Join(ctx, Join{
+			Left:  JoinSide{Collection: "people", Query: New(Eq(name, ScalarMustParse("Jane Doe"))), TermPath: worksFor},
+			Right: JoinSide{Collection: "organizations"},
+		})
+
+		if !assert.NoError(t, err) || !assert.Len(t, pairs, 1) {
+			return
+		}
+		assert.Equal(t, Document(joinJane), pairs[0].Left)
+		assert.Equal(t, Document(joinNuts), pairs[0].Right)
+	})
+
+	t.Run("ok - queries on both sides", func(t *testing.T) {
+		s := joinStore(t)
+
+		pairs, err := s.Join(ctx, Join{
+			Left:  JoinSide{Collection: "people", Query: New(Eq(name, ScalarMustParse("John Doe"))), TermPath: worksFor},
+			Right: JoinSide{Collection: "organizations", Query: New(Eq(name, ScalarMustParse("Nuts")))},
+		})
+
+		if !assert.NoError(t, err) || !assert.Len(t, pairs, 1) {
+			return
+		}
+		assert.Equal(t, Document(joinJohn), pairs[0].Left)
+	})
+
+	t.Run("ok - on term path values", func(t *testing.T) {
+		s := joinStore(t)
+		_ = s.Collection("names").Add([]Document{jsonLdExample})
+
+		pairs, err := s.Join(ctx, Join{
+			Left:  JoinSide{Collection: "people", TermPath: name},
+			Right: JoinSide{Collection: "names", TermPath: NewTermPath("http://schema.org/name")},
+		})
+
+		if !assert.NoError(t, err) || !assert.Len(t, pairs, 1) {
+			return
+		}
+		assert.Equal(t, Document(joinJane), pairs[0].Left)
+		assert.Equal(t, Document(jsonLdExample), pairs[0].Right)
+	})
+
+	t.Run("error - no left TermPath", func(t *testing.T) {
+		s := joinStore(t)
+
+		_, err := s.Join(ctx, Join{
+			Left:  JoinSide{Collection: "people"},
+			Right: JoinSide{Collection: "organizations"},
+		})
+
+		assert.Equal(t, ErrInvalidJoin, err)
+	})
+
+	t.Run("error - unknown collection", func(t *testing.T) {
+		s := joinStore(t)
+
+		_, err := s.Join(ctx, Join{
+			Left:  JoinSide{Collection: "people", TermPath: worksFor},
+			Right: JoinSide{Collection: "organisations"},
+		})
+
+		assert.True(t, errors.Is(err, ErrUnknownCollection))
+		// the collection isn't created with the default options
+		assert.NotContains(t, s.(*store).collections, "organisations")
+	})
+
+	t.Run("error - cancelled context", func(t *testing.T) {
+		s := joinStore(t)
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := s.Join(cancelled, Join{
+			Left:  JoinSide{Collection: "people", TermPath: worksFor},
+			Right: JoinSide{Collection: "organizations"},
+		})
+
+		assert.Equal(t, context.Canceled, err)
+	})
+}
@@ -19,7 +19,8 @@
 
 package goauld
 
-import "bytes"
+// keyTerminator follows the KeyDelimiter to mark the end of a key part
+const keyTerminator = 0x00
 
 // Key is used as DB key type
 type Key []byte
@@ -42,30 +43,53 @@ func (k Key) String() string {
 	return string(k)
 }
 
-// ComposeKey creates a new key from two keys
+// ComposeKey creates a new key by appending an additional part to the current (compound) key.
+// Each part is escaped and terminated, so parts may contain any byte, including the KeyDelimiter.
+// The encoding preserves the order of the parts: compound keys sort the same as their parts would.
+// Bytes up to and including the KeyDelimiter are escaped as the KeyDelimiter followed by the byte + 1.
+// The KeyDelimiter followed by a 0 byte terminates a part.
 func ComposeKey(current Key, additional Key) Key {
-	if len(current) == 0 {
-		return additional
-	}
+	result := make([]byte, len(current), len(current)+len(additional)+2)
+	copy(result, current)
 
-	c := current.Split()
-	b := make([][]byte, len(c))
-	for i, k := range c {
-		b[i] = k
+	for _, b := range additional {
+		if b <= KeyDelimiter {
+			result = append(result, KeyDelimiter, b+1)
+		} else {
+			result = append(result, b)
+		}
 	}
 
-	b = append(b, additional)
-	return bytes.Join(b, []byte{KeyDelimiter})
+	return append(result, KeyDelimiter, keyTerminator)
 }
 
-// Split splits a compound key into parts
+// Split splits a compound key into the original parts
 func (k Key) Split() []Key {
-	s := bytes.Split(k, []byte{KeyDelimiter})
-	var nk = make([]Key, len(s))
+	parts := make([]Key, 0)
+	part := Key{}
+
+	for j := 0; j < len(k); j++ {
+		if k[j] != KeyDelimiter {
+			part = append(part, k[j])
+			continue
+		}
+		j++
+		if j == len(k) {
+			// truncated key
+			break
+		}
+		if k[j] == keyTerminator {
+			parts = append(parts, part)
+			part = Key{}
+			continue
+		}
+		part = append(part, k[j]-1)
+	}
 
-	for i, si := range s {
-		nk[i] = si
+	// a partial key without terminator
+	if len(part) > 0 {
+		parts = append(parts, part)
 	}
 
-	return nk
+	return parts
 }
@@ -20,8 +20,10 @@
 package goauld
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
+	"testing/quick"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -30,22 +32,22 @@ func TestComposeKey(t *testing.T) {
 	t.Run("ok - empty keys", func(t *testing.T) {
 		k := ComposeKey(nil, nil)
 
-		assert.Nil(t, k)
+		assert.Equal(t, Key{KeyDelimiter, keyTerminator}, k)
 	})
 
 	t.Run("ok - initial key", func(t *testing.T) {
 		a := Key("additional")
 		k := ComposeKey(nil, a)
 
-		assert.Equal(t, a, k)
+		assert.Equal(t, Key(fmt.Sprintf("additional%c%c", KeyDelimiter, keyTerminator)), k)
 	})
 
 	t.Run("ok - multiple key", func(t *testing.T) {
 		k1 := Key("first")
 		k2 := Key("second")
-		exp := Key(fmt.Sprintf("first%csecond", KeyDelimiter))
+		exp := Key(fmt.Sprintf("first%c%csecond%c%c", KeyDelimiter, keyTerminator, KeyDelimiter, keyTerminator))
 
-		k := ComposeKey(k1, k2)
+		k := ComposeKey(ComposeKey(nil, k1), k2)
 
 		assert.Equal(t, exp, k)
 	})
@@ -54,17 +56,23 @@ func TestComposeKey(t *testing.T) {
 		k1 := Key("first")
 		k2 := Key([]byte{})
 		k3 := Key([]byte{})
-		exp := Key(fmt.Sprintf("first%c%c", KeyDelimiter, KeyDelimiter))
+		exp := Key(fmt.Sprintf("first%c%c%c%c%c%c", KeyDelimiter, keyTerminator, KeyDelimiter, keyTerminator, KeyDelimiter, keyTerminator))
 
-		k := ComposeKey(ComposeKey(k1, k2), k3)
+		k := ComposeKey(ComposeKey(ComposeKey(nil, k1), k2), k3)
 
 		assert.Equal(t, exp, k)
 	})
+
+	t.Run("ok - delimiter is escaped", func(t *testing.T) {
+		k := ComposeKey(nil, Key{'a', KeyDelimiter, 0x00})
+
+		assert.Equal(t, Key{'a', KeyDelimiter, KeyDelimiter + 1, KeyDelimiter, 0x01, KeyDelimiter, keyTerminator}, k)
+	})
 }
 
 func TestKey_Split(t *testing.T) {
 	t.Run("ok - single key", func(t *testing.T) {
-		s := Key("first").Split()
+		s := ComposeKey(nil, Key("first")).Split()
 
 		assert.Len(t, s, 1)
 	})
@@ -72,7 +80,7 @@ func TestKey_Split(t *testing.T) {
 	t.Run("ok - multiple keys", func(t *testing.T) {
 		k1 := Key("first")
 		k2 := Key("second")
-		c := Key(fmt.Sprintf("first%csecond", KeyDelimiter))
+		c := ComposeKey(ComposeKey(nil, k1), k2)
 
 		s := c.Split()
 
@@ -80,4 +88,106 @@ func TestKey_Split(t *testing.T) {
 		assert.Equal(t, k1, s[0])
 		assert.Equal(t, k2, s[1])
 	})
+
+	t.Run("ok - empty parts", func(t *testing.T) {
+		c := ComposeKey(ComposeKey(nil, Key{}), Key("second"))
+
+		s := c.Split()
+
+		assert.Len(t, s, 2)
+		assert.Equal(t, Key{}, s[0])
+		assert.Equal(t, Key("second"), s[1])
+	})
+
+	t.Run("ok - partial key without terminator", func(t *testing.T) {
+		s := Key("first").Split()
+
+		assert.Equal(t, []Key{Key("first")}, s)
+	})
+}
+
+func TestComposeKey_Properties(t *testing.T) {
+	roundTrip := func(parts ...Scalar) bool {
+		var k Key
+		for _, p := range parts {
+			k = ComposeKey(k, p.Bytes())
+		}
+		split := k.Split()
+		if len(split) != len(parts) {
+			return false
+		}
+		for i, p := range parts {
+			if !bytes.Equal(split[i], p.Bytes()) {
+				return false
+			}
+		}
+		return true
+	}
+
+	t.Run("ok - strings round trip", func(t *testing.T) {
+		f := func(a string, b string, c string) bool {
+			return roundTrip(ScalarMustParse(a), ScalarMustParse(b), ScalarMustParse(c))
+		}
+
+		assert.NoError(t, quick.Check(f, nil))
+	})
+
+	t.Run("ok - numbers round trip", func(t *testing.T) {
+		f := func(a float64, b float64) bool {
+			return roundTrip(ScalarMustParse(a), ScalarMustParse(b))
+		}
+
+		assert.NoError(t, quick.Check(f, nil))
+	})
+
+	t.Run("ok - booleans round trip", func(t *testing.T) {
+		f := func(a bool, b bool) bool {
+			return roundTrip(ScalarMustParse(a), ScalarMustParse(b))
+		}
+
+		assert.NoError(t, quick.Check(f, nil))
+	})
+
+	t.Run("ok - mixed scalars round trip", func(t *testing.T) {
+		f := func(a string, b float64, c bool) bool {
+			return roundTrip(ScalarMustParse(a), ScalarMustParse(b), ScalarMustParse(c))
+		}
+
+		assert.NoError(t, quick.Check(f, nil))
+	})
+
+	t.Run("ok - raw bytes round trip", func(t *testing.T) {
+		f := func(a []byte, b []byte) bool {
+			split := ComposeKey(ComposeKey(nil, a), b).Split()
+			return len(split) == 2 && bytes.Equal(split[0], a) && bytes.Equal(split[1], b)
+		}
+
+		assert.NoError(t, quick.Check(f, nil))
+	})
+
+	t.Run("ok - order is preserved", func(t *testing.T) {
+		f := func(a1 []byte, a2 []byte, b1 []byte, b2 []byte) bool {
+			expected := bytes.Compare(a1, b1)
+			if expected == 0 {
+				expected = bytes.Compare(a2, b2)
+			}
+			a := ComposeKey(ComposeKey(nil, a1), a2)
+			b := ComposeKey(ComposeKey(nil, b1), b2)
+			return bytes.Compare(a, b) == expected
+		}
+
+		assert.NoError(t, quick.Check(f, nil))
+	})
+
+	t.Run("ok - shorter parts sort first", func(t *testing.T) {
+		f := func(a []byte, suffix []byte, b []byte) bool {
+			if len(suffix) == 0 {
+				return true
+			}
+			long := append(append([]byte{}, a...), suffix...)
+			return bytes.Compare(ComposeKey(ComposeKey(nil, a), b), ComposeKey(nil, long)) < 0
+		}
+
+		assert.NoError(t, quick.Check(f, nil))
+	})
 }
@@ -0,0 +1,333 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// tokenKind is the kind of token of the SPARQL subset and the textual query language
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIRI
+	tokenPrefixedName
+	tokenVariable
+	tokenString
+	// tokenAtWord is '@' followed by letters: a language tag or a JSON-LD keyword
+	tokenAtWord
+	tokenNumber
+	tokenWord
+	tokenPunctuation
+)
+
+// lexToken is a token of the query, text is the value without quotes or brackets and pos and end are the offsets in the query
+type lexToken struct {
+	kind tokenKind
+	text string
+	pos  int
+	end  int
+}
+
+// punctuations contains the operators and punctuation, longest first
+var punctuations = []string{"^^", "!=", "<=", ">=", "&&", "||", "**", "{", "}", "(", ")", ".", ";", ",", "*", "/", "=", "<", ">", "!"}
+
+// lexTokens splits the text into tokens, errors wrap the sentinel error
+func lexTokens(text string, sentinel error) ([]lexToken, error) {
+	tokens := make([]lexToken, 0)
+	pos := 0
+	emit := func(kind tokenKind, value string, end int) {
+		tokens = append(tokens, lexToken{kind: kind, text: value, pos: pos, end: end})
+		pos = end
+	}
+	for pos < len(text) {
+		c := rune(text[pos])
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+		case c == '#':
+			for pos < len(text) && text[pos] != '\n' {
+				pos++
+			}
+		case c == '<' && isIRIRef(text[pos:]):
+			end := pos + strings.IndexByte(text[pos:], '>')
+			emit(tokenIRI, text[pos+1:end], end+1)
+		case c == '?' || c == '$':
+			end := pos + 1
+			for end < len(text) && isNameChar(rune(text[end])) {
+				end++
+			}
+			if end == pos+1 {
+				return nil, syntaxError(sentinel, text, pos, "expected a variable name")
+			}
+			emit(tokenVariable, text[pos+1:end], end)
+		case c == '"' || c == '\'':
+			value, end, err := lexString(text, pos, sentinel)
+			if err != nil {
+				return nil, err
+			}
+			emit(tokenString, value, end)
+		case c == '@':
+			end := pos + 1
+			for end < len(text) && (isLetter(rune(text[end])) || text[end] == '-') {
+				end++
+			}
+			emit(tokenAtWord, text[pos+1:end], end)
+		case unicode.IsDigit(c) || ((c == '-' || c == '+') && pos+1 < len(text) && unicode.IsDigit(rune(text[pos+1]))):
+			end := lexNumber(text, pos)
+			emit(tokenNumber, text[pos:end], end)
+		case isLetter(c) || c == ':':
+			end := pos
+			for end < len(text) && (isNameChar(rune(text[end])) || text[end] == ':' || text[end] == '.' || text[end] == '-') {
+				end++
+			}
+			// a trailing dot ends the triple
+			for text[end-1] == '.' {
+				end--
+			}
+			word := text[pos:end]
+			kind := tokenWord
+			if strings.Contains(word, ":") {
+				kind = tokenPrefixedName
+			}
+			emit(kind, word, end)
+		default:
+			found := false
+			for _, punctuation := range punctuations {
+				if strings.HasPrefix(text[pos:], punctuation) {
+					emit(tokenPunctuation, punctuation, pos+len(punctuation))
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, syntaxError(sentinel, text, pos, "unexpected character %q", c)
+			}
+		}
+	}
+	return append(tokens, lexToken{kind: tokenEOF, pos: len(text), end: len(text)}), nil
+}
+
+// isIRIRef returns true if the text starts with an IRI between angle brackets, otherwise the '<' is an operator
+func isIRIRef(text string) bool {
+	for j := 1; j < len(text); j++ {
+		switch text[j] {
+		case '>':
+			return true
+		case ' ', '\t', '\n', '\r', '<', '"', '{', '}':
+			return false
+		}
+	}
+	return false
+}
+
+func lexString(text string, pos int, sentinel error) (string, int, error) {
+	quote := text[pos]
+	builder := strings.Builder{}
+	for j := pos + 1; j < len(text); j++ {
+		switch text[j] {
+		case quote:
+			return builder.String(), j + 1, nil
+		case '\n':
+			return "", 0, syntaxError(sentinel, text, pos, "unterminated string")
+		case '\\':
+			j++
+			if j == len(text) {
+				return "", 0, syntaxError(sentinel, text, pos, "unterminated string")
+			}
+			switch text[j] {
+			case 'n':
+				builder.WriteByte('\n')
+			case 't':
+				builder.WriteByte('\t')
+			case 'r':
+				builder.WriteByte('\r')
+			case '"', '\'', '\\':
+				builder.WriteByte(text[j])
+			default:
+				return "", 0, syntaxError(sentinel, text, j-1, "invalid escape sequence \\%c", text[j])
+			}
+		default:
+			builder.WriteByte(text[j])
+		}
+	}
+	return "", 0, syntaxError(sentinel, text, pos, "unterminated string")
+}
+
+// lexNumber returns the end of the number starting at pos, a dot is only part of the number when followed by a digit
+func lexNumber(text string, pos int) int {
+	end := pos + 1
+	digits := func() {
+		for end < len(text) && unicode.IsDigit(rune(text[end])) {
+			end++
+		}
+	}
+	digits()
+	if end+1 < len(text) && text[end] == '.' && unicode.IsDigit(rune(text[end+1])) {
+		end++
+		digits()
+	}
+	if end < len(text) && (text[end] == 'e' || text[end] == 'E') {
+		exponent := end + 1
+		if exponent < len(text) && (text[exponent] == '-' || text[exponent] == '+') {
+			exponent++
+		}
+		if exponent < len(text) && unicode.IsDigit(rune(text[exponent])) {
+			end = exponent
+			digits()
+		}
+	}
+	return end
+}
+
+func isLetter(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c rune) bool {
+	return isLetter(c) || unicode.IsDigit(c) || c == '_'
+}
+
+// syntaxError returns an error wrapping the sentinel error with the line and column of the position
+func syntaxError(sentinel error, text string, pos int, format string, args ...interface{}) error {
+	line := strings.Count(text[:pos], "\n") + 1
+	column := pos - strings.LastIndex(text[:pos], "\n")
+	return fmt.Errorf("%w: line %d, column %d: %s", sentinel, line, column, fmt.Sprintf(format, args...))
+}
+
+// tokenParser contains the helpers shared by the parsers of the SPARQL subset and the textual query language.
+// Errors wrap the sentinel error.
+type tokenParser struct {
+	sentinel error
+	text     string
+	tokens   []lexToken
+	current  int
+	prefixes map[string]string
+}
+
+func newTokenParser(text string, sentinel error) (*tokenParser, error) {
+	tokens, err := lexTokens(text, sentinel)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenParser{sentinel: sentinel, text: text, tokens: tokens, prefixes: map[string]string{}}, nil
+}
+
+func (p *tokenParser) peek() lexToken {
+	return p.tokens[p.current]
+}
+
+func (p *tokenParser) next() lexToken {
+	token := p.tokens[p.current]
+	if token.kind != tokenEOF {
+		p.current++
+	}
+	return token
+}
+
+func (p *tokenParser) errorf(token lexToken, format string, args ...interface{}) error {
+	return syntaxError(p.sentinel, p.text, token.pos, format, args...)
+}
+
+// unexpected returns an error for a token that doesn't match what's expected
+func (p *tokenParser) unexpected(token lexToken, expected string) error {
+	found := "end of query"
+	if token.kind != tokenEOF {
+		found = fmt.Sprintf("%q", p.text[token.pos:token.end])
+	}
+	return p.errorf(token, "expected %s, found %s", expected, found)
+}
+
+// isKeyword returns true if the next token is the keyword, keywords are case-insensitive
+func (p *tokenParser) isKeyword(keyword string) bool {
+	token := p.peek()
+	return token.kind == tokenWord && strings.EqualFold(token.text, keyword)
+}
+
+func (p *tokenParser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *tokenParser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.unexpected(p.peek(), keyword)
+	}
+	return nil
+}
+
+func (p *tokenParser) isPunctuation(punctuation string) bool {
+	token := p.peek()
+	return token.kind == tokenPunctuation && token.text == punctuation
+}
+
+func (p *tokenParser) acceptPunctuation(punctuation string) bool {
+	if p.isPunctuation(punctuation) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *tokenParser) expectPunctuation(punctuation string) error {
+	if !p.acceptPunctuation(punctuation) {
+		return p.unexpected(p.peek(), fmt.Sprintf("%q", punctuation))
+	}
+	return nil
+}
+
+// parsePrefixes parses the PREFIX declarations, like "PREFIX schema: <http://schema.org/>"
+func (p *tokenParser) parsePrefixes() error {
+	for p.acceptKeyword("PREFIX") {
+		token := p.next()
+		if token.kind != tokenPrefixedName || !strings.HasSuffix(token.text, ":") || strings.Count(token.text, ":") != 1 {
+			return p.unexpected(token, "a prefix name")
+		}
+		iri := p.next()
+		if iri.kind != tokenIRI {
+			return p.unexpected(iri, "an IRI")
+		}
+		p.prefixes[strings.TrimSuffix(token.text, ":")] = iri.text
+	}
+	return nil
+}
+
+// parseIRI returns the IRI of the next token, prefixed names are expanded
+func (p *tokenParser) parseIRI() (string, error) {
+	token := p.next()
+	switch token.kind {
+	case tokenIRI:
+		return token.text, nil
+	case tokenPrefixedName:
+		separator := strings.IndexByte(token.text, ':')
+		namespace, ok := p.prefixes[token.text[:separator]]
+		if !ok {
+			return "", p.errorf(token, "unknown prefix %q", token.text[:separator])
+		}
+		return namespace + token.text[separator+1:], nil
+	}
+	return "", p.unexpected(token, "an IRI")
+}
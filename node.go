@@ -0,0 +1,252 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"errors"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// nodeBucket is the bucket next to the documentBucket that maps node @ids to the references of the documents describing the node
+const nodeBucket = "_nodes"
+
+// ErrNoNodeIndex is returned when a TermPath with the DereferenceTerm is evaluated on a collection without node index
+var ErrNoNodeIndex = errors.New("collection has no node index")
+
+// ErrDereferenceIndex is returned when an index is built on a TermPath with the DereferenceTerm.
+// The entries of such an index would change with every other document that describes a dereferenced node.
+var ErrDereferenceIndex = errors.New("a TermPath with the DereferenceTerm can't be indexed")
+
+// WithNodeIndex flattens every document that is added and maps the @id of every node it describes to the document.
+// Nodes that are only referenced by their @id and blank nodes aren't mapped.
+// The node index is required for term paths with the DereferenceTerm, which follow @id values into other stored documents.
+// Documents that were added without this option aren't part of the node index.
+func WithNodeIndex() CollectionOption {
+	return func(collection *collection) {
+		collection.nodeIndex = true
+	}
+}
+
+// hasDereference returns true if the TermPath contains the DereferenceTerm
+func hasDereference(termPath TermPath) bool {
+	for _, term := range termPath.Terms {
+		if term == DereferenceTerm {
+			return true
+		}
+	}
+	return false
+}
+
+// splitAtDereference returns the terms before and after the first DereferenceTerm.
+// It returns false if the TermPath doesn't contain the DereferenceTerm.
+func splitAtDereference(termPath TermPath) (TermPath, TermPath, bool) {
+	for j, term := range termPath.Terms {
+		if term == DereferenceTerm {
+			return TermPath{Terms: termPath.Terms[:j]}, TermPath{Terms: termPath.Terms[j+1:]}, true
+		}
+	}
+	return termPath, TermPath{}, false
+}
+
+// checkIndexable returns ErrDereferenceIndex when one of the parts of the index dereferences nodes
+func checkIndexable(i Index) error {
+	if idx, ok := i.(*index); ok {
+		for _, part := range idx.indexParts {
+			if hasDereference(part.TermPath()) {
+				return ErrDereferenceIndex
+			}
+		}
+	}
+	return nil
+}
+
+// nodeIDs flattens the expanded document and returns the @id of every node described by the document.
+// Nodes of named graphs are included.
+func (c *collection) nodeIDs(expanded []interface{}) ([]string, error) {
+	flattened, err := c.documentProcessor.Flatten(expanded, nil, c.jsonLdOptions())
+	if err != nil {
+		return nil, err
+	}
+	nodes, _ := flattened.([]interface{})
+
+	ids := make([]string, 0, len(nodes))
+	forEachFlattenedNode(nodes, func(id string, node map[string]interface{}) {
+		ids = append(ids, id)
+	})
+	return ids, nil
+}
+
+// forEachFlattenedNode calls fn for every node with an IRI as @id, including the nodes of named graphs
+func forEachFlattenedNode(nodes []interface{}, fn func(id string, node map[string]interface{})) {
+	for _, n := range nodes {
+		node, ok := n.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if id, ok := node["@id"].(string); ok && !strings.HasPrefix(id, "_:") {
+			fn(id, node)
+		}
+		if graph, ok := node["@graph"].([]interface{}); ok {
+			forEachFlattenedNode(graph, fn)
+		}
+	}
+}
+
+// preparedNodeIDs returns the node @ids of the prepared document, the document is expanded if needed
+func (c *collection) preparedNodeIDs(p preparedDocument) ([]string, error) {
+	expanded := p.expanded
+	if expanded == nil {
+		var err error
+		if expanded, err = c.expand(p.doc); err != nil {
+			return nil, err
+		}
+	}
+	return c.nodeIDs(expanded)
+}
+
+// putNodes adds the nodes of the document to the node index when configured
+func (c *collection) putNodes(bucket *bbolt.Bucket, p preparedDocument) error {
+	if !c.nodeIndex {
+		return nil
+	}
+
+	nBucket, err := bucket.CreateBucketIfNotExists([]byte(nodeBucket))
+	if err != nil {
+		return err
+	}
+	ids, err := c.preparedNodeIDs(p)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err = addRefToBucket(nBucket, Key(id), p.ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteNodes removes the nodes of the document from the node index, if the collection has one
+func (c *collection) deleteNodes(bucket *bbolt.Bucket, p preparedDocument) error {
+	nBucket := bucket.Bucket([]byte(nodeBucket))
+	if nBucket == nil {
+		return nil
+	}
+
+	ids, err := c.preparedNodeIDs(p)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err = removeRefFromBucket(nBucket, Key(id), p.ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveNode returns the node with the given @id from every stored document that describes it.
+// The documents are flattened, so each result is a node object with references to other nodes by @id.
+func (c *collection) resolveNode(tx *bbolt.Tx, id string) ([]interface{}, error) {
+	bucket := c.bucket(tx)
+	if bucket == nil {
+		return nil, nil
+	}
+	nBucket := bucket.Bucket([]byte(nodeBucket))
+	if nBucket == nil {
+		if c.nodeIndex {
+			return nil, nil
+		}
+		return nil, ErrNoNodeIndex
+	}
+	refBucket := nBucket.Bucket(Key(id))
+	if refBucket == nil {
+		return nil, nil
+	}
+
+	docBucket := bucket.Bucket(documentBucketByteRef())
+	expand := c.expander(tx)
+	result := make([]interface{}, 0)
+	err := refBucket.ForEach(func(ref, _ []byte) error {
+		doc := docBucket.Get(ref)
+		if doc == nil {
+			return nil
+		}
+		expanded, err := expand(ref, doc)
+		if err != nil {
+			return err
+		}
+		flattened, err := c.documentProcessor.Flatten(expanded, nil, c.jsonLdOptions())
+		if err != nil {
+			return err
+		}
+		nodes, _ := flattened.([]interface{})
+		forEachFlattenedNode(nodes, func(nodeID string, node map[string]interface{}) {
+			if nodeID == id {
+				result = append(result, node)
+			}
+		})
+		return nil
+	})
+	return result, err
+}
+
+// pathEvaluator returns a function that evaluates a TermPath against an expanded document.
+// The DereferenceTerm is resolved with the node index within the given transaction.
+func (c *collection) pathEvaluator(tx *bbolt.Tx) func(expanded []interface{}, termPath TermPath) ([]Scalar, error) {
+	return func(expanded []interface{}, termPath TermPath) ([]Scalar, error) {
+		head, tail, ok := splitAtDereference(termPath)
+		if !ok {
+			return valuesFromDocumentAtPath(expanded, termPath), nil
+		}
+		return c.dereference(tx, valuesFromDocumentAtPath(expanded, head), tail)
+	}
+}
+
+// dereference resolves the IRIs to nodes and continues the evaluation of the termPath on those nodes
+func (c *collection) dereference(tx *bbolt.Tx, ids []Scalar, termPath TermPath) ([]Scalar, error) {
+	result := make([]Scalar, 0)
+	seen := map[string]bool{}
+	for _, id := range ids {
+		iri, ok := id.value.(string)
+		if !ok || seen[iri] {
+			continue
+		}
+		seen[iri] = true
+
+		nodes, err := c.resolveNode(tx, iri)
+		if err != nil {
+			return nil, err
+		}
+		head, tail, ok := splitAtDereference(termPath)
+		if !ok {
+			result = append(result, valuesFromSliceAtPath(nodes, termPath)...)
+			continue
+		}
+		values, err := c.dereference(tx, valuesFromSliceAtPath(nodes, head), tail)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, values...)
+	}
+	return result, nil
+}
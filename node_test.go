@@ -0,0 +1,205 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+var nodeEmployeeExample = []byte(`
+{
+  "@context": ["http://schema.org/"],
+  "@id": "http://example.com/jane",
+  "@type": "Person",
+  "name": "Jane Doe",
+  "worksFor": {"@id": "http://example.com/nuts"}
+}
+`)
+
+var nodeOrganizationExample = []byte(`
+{
+  "@context": ["http://schema.org/"],
+  "@id": "http://example.com/nuts",
+  "@type": "Organization",
+  "name": "Nuts",
+  "parentOrganization": {"@id": "http://example.com/foundation"}
+}
+`)
+
+var nodeParentExample = []byte(`
+{
+  "@context": ["http://schema.org/"],
+  "@graph": [
+    {
+      "@id": "http://example.com/foundation",
+      "name": "Nuts Foundation"
+    },
+    {
+      "name": "anonymous"
+    }
+  ]
+}
+`)
+
+func TestWithNodeIndex(t *testing.T) {
+	worksFor := "http://schema.org/worksFor"
+	name := "http://schema.org/name"
+	employerName := NewTermPath(worksFor, DereferenceTerm, name)
+	nodeCollection := func(t *testing.T) Collection {
+		c := testStore(t).Collection("test", WithNodeIndex())
+		_ = c.Add([]Document{nodeEmployeeExample, nodeOrganizationExample, nodeParentExample})
+		return c
+	}
+
+	t.Run("ok - dereference an @id into another document", func(t *testing.T) {
+		c := nodeCollection(t)
+
+		values, err := c.ValuesAtPath(nodeEmployeeExample, employerName)
+
+		if !assert.NoError(t, err) || !assert.Len(t, values, 1) {
+			return
+		}
+		assert.Equal(t, "Nuts", string(values[0].Bytes()))
+	})
+
+	t.Run("ok - dereference multiple times", func(t *testing.T) {
+		c := nodeCollection(t)
+		termPath := NewTermPath(worksFor, DereferenceTerm, "http://schema.org/parentOrganization", DereferenceTerm, name)
+
+		values, err := c.ValuesAtPath(nodeEmployeeExample, termPath)
+
+		if !assert.NoError(t, err) || !assert.Len(t, values, 1) {
+			return
+		}
+		assert.Equal(t, "Nuts Foundation", string(values[0].Bytes()))
+	})
+
+	t.Run("ok - find by a value of a dereferenced node", func(t *testing.T) {
+		c := nodeCollection(t)
+
+		docs, err := c.Find(context.Background(), New(Eq(employerName, ScalarMustParse("Nuts"))))
+
+		assert.NoError(t, err)
+		assert.Equal(t, []Document{nodeEmployeeExample}, docs)
+	})
+
+	t.Run("ok - unknown node", func(t *testing.T) {
+		c := nodeCollection(t)
+		termPath := NewTermPath(name, DereferenceTerm, name)
+
+		values, err := c.ValuesAtPath(nodeEmployeeExample, termPath)
+
+		assert.NoError(t, err)
+		assert.Len(t, values, 0)
+	})
+
+	t.Run("ok - node is removed with the document", func(t *testing.T) {
+		c := nodeCollection(t)
+
+		_ = c.Delete(nodeOrganizationExample)
+		values, err := c.ValuesAtPath(nodeEmployeeExample, employerName)
+
+		assert.NoError(t, err)
+		assert.Len(t, values, 0)
+	})
+
+	t.Run("ok - only described nodes with an IRI are mapped", func(t *testing.T) {
+		c := nodeCollection(t).(*collection)
+
+		var ids []string
+		_ = c.db.View(func(tx *bbolt.Tx) error {
+			return testBucket(t, tx).Bucket([]byte(nodeBucket)).ForEach(func(k, _ []byte) error {
+				ids = append(ids, string(k))
+				return nil
+			})
+		})
+
+		assert.Equal(t, []string{"http://example.com/foundation", "http://example.com/jane", "http://example.com/nuts"}, ids)
+	})
+
+	t.Run("ok - upsert with a dereferenced identity within a transaction", func(t *testing.T) {
+		s := testStore(t)
+		_ = s.Collection("test", WithNodeIndex(), WithIdentityTermPath(employerName)).
+			Add([]Document{nodeEmployeeExample, nodeOrganizationExample})
+		other := []byte(`{"@context": ["http://schema.org/"], "@id": "http://example.com/john", "worksFor": {"@id": "http://example.com/nuts"}}`)
+
+		var replaced bool
+		err := s.Update(func(tx WriteTx) error {
+			var err error
+			replaced, err = tx.Collection("test").Upsert(other)
+			return err
+		})
+
+		assert.NoError(t, err)
+		assert.True(t, replaced)
+		docs, _ := s.Collection("test").Find(context.Background(), New(Eq(employerName, ScalarMustParse("Nuts"))))
+		assert.Equal(t, []Document{other}, docs)
+	})
+
+	t.Run("error - collection without node index", func(t *testing.T) {
+		c := testStore(t).Collection("test")
+		_ = c.Add([]Document{nodeEmployeeExample, nodeOrganizationExample})
+
+		_, err := c.ValuesAtPath(nodeEmployeeExample, employerName)
+
+		assert.Equal(t, ErrNoNodeIndex, err)
+	})
+
+	t.Run("error - index on a dereferenced TermPath", func(t *testing.T) {
+		c := nodeCollection(t)
+
+		err := c.AddIndex(c.NewIndex("employer", NewFieldIndexer(employerName)))
+
+		assert.True(t, errors.Is(err, ErrDereferenceIndex))
+	})
+
+	t.Run("error - keys of a dereferenced TermPath", func(t *testing.T) {
+		c := nodeCollection(t)
+		i := c.NewIndex("employer", NewFieldIndexer(employerName))
+
+		_, err := documentKeys(i, nodeEmployeeExample, nil)
+		assert.Equal(t, ErrDereferenceIndex, err)
+
+		expanded, _ := c.(*collection).expand(nodeEmployeeExample)
+		_, err = documentKeys(i, nodeEmployeeExample, expanded)
+		assert.Equal(t, ErrDereferenceIndex, err)
+	})
+}
+
+func TestSplitAtDereference(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		head, tail, ok := splitAtDereference(NewTermPath("a", DereferenceTerm, "b", DereferenceTerm, "c"))
+
+		assert.True(t, ok)
+		assert.True(t, head.Equals(NewTermPath("a")))
+		assert.True(t, tail.Equals(NewTermPath("b", DereferenceTerm, "c")))
+	})
+
+	t.Run("ok - without DereferenceTerm", func(t *testing.T) {
+		_, _, ok := splitAtDereference(NewTermPath("a", "b"))
+
+		assert.False(t, ok)
+	})
+}
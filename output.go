@@ -0,0 +1,99 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"encoding/json"
+)
+
+// OutputOption changes the form of the documents returned by Find and Get.
+// Without options, documents are returned as the bytes that were stored.
+type OutputOption func(options *outputOptions)
+
+type outputOptions struct {
+	context interface{}
+	frame   interface{}
+}
+
+// WithCompaction compacts the returned documents against the given JSON-LD context.
+// The context is given in the form json-gold accepts: an IRI, a context object (with or without @context) or an array of those.
+func WithCompaction(context interface{}) OutputOption {
+	return func(options *outputOptions) {
+		options.context = context
+		options.frame = nil
+	}
+}
+
+// WithFrame frames the returned documents with the given JSON-LD frame, either a frame object or an IRI.
+// The result is compacted against the context of the frame.
+func WithFrame(frame interface{}) OutputOption {
+	return func(options *outputOptions) {
+		options.frame = frame
+		options.context = nil
+	}
+}
+
+// output applies the options to the documents, the documents are returned as is when no options are given.
+// The documents are processed with the document loader and processor of the collection.
+func (c *collection) output(docs []Document, options []OutputOption) ([]Document, error) {
+	if len(options) == 0 {
+		return docs, nil
+	}
+
+	o := outputOptions{}
+	for _, option := range options {
+		option(&o)
+	}
+
+	result := make([]Document, len(docs))
+	for j, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		var err error
+		if result[j], err = c.outputDocument(doc, o); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (c *collection) outputDocument(doc Document, o outputOptions) (Document, error) {
+	var input interface{}
+	if err := json.Unmarshal(doc, &input); err != nil {
+		return nil, err
+	}
+
+	var output map[string]interface{}
+	var err error
+	switch {
+	case o.frame != nil:
+		output, err = c.documentProcessor.Frame(input, o.frame, c.jsonLdOptions())
+	case o.context != nil:
+		output, err = c.documentProcessor.Compact(input, o.context, c.jsonLdOptions())
+	default:
+		return doc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(output)
+}
@@ -0,0 +1,107 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollection_Output(t *testing.T) {
+	nameQuery := New(Eq(NewTermPath("http://schema.org/name"), ScalarMustParse("Jane Doe")))
+	compactContext := map[string]interface{}{
+		"@context": map[string]interface{}{
+			"fullName": "http://schema.org/name",
+			"kids":     "http://schema.org/children",
+		},
+	}
+	c := createCollection(testDB(t))
+	_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+
+	t.Run("ok - stored bytes without options", func(t *testing.T) {
+		doc, err := c.Get(c.Reference(jsonLdExample))
+
+		assert.NoError(t, err)
+		assert.Equal(t, Document(jsonLdExample), doc)
+	})
+
+	t.Run("ok - find compacted", func(t *testing.T) {
+		docs, err := c.Find(context.Background(), nameQuery, WithCompaction(compactContext))
+
+		if !assert.NoError(t, err) || !assert.Len(t, docs, 1) {
+			return
+		}
+		result := map[string]interface{}{}
+		_ = json.Unmarshal(docs[0], &result)
+		assert.Equal(t, "Jane Doe", result["fullName"])
+		assert.Equal(t, map[string]interface{}{"fullName": "John Doe"}, result["kids"])
+		assert.Equal(t, compactContext["@context"], result["@context"])
+	})
+
+	t.Run("ok - get framed", func(t *testing.T) {
+		frame := map[string]interface{}{
+			"@context": map[string]interface{}{
+				"name":     "http://schema.org/name",
+				"children": "http://schema.org/children",
+			},
+			"@explicit": true,
+			"name":      map[string]interface{}{},
+			"children":  map[string]interface{}{"@explicit": true, "name": map[string]interface{}{}},
+		}
+
+		doc, err := c.Get(c.Reference(jsonLdExample), WithFrame(frame))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Contains(t, string(doc), `"name":"Jane Doe"`)
+		assert.Contains(t, string(doc), `"children":{"name":"John Doe"}`)
+		assert.NotContains(t, string(doc), "jobTitle")
+	})
+
+	t.Run("ok - not found", func(t *testing.T) {
+		doc, err := c.Get(Reference("unknown"), WithCompaction(compactContext))
+
+		assert.NoError(t, err)
+		assert.Nil(t, doc)
+	})
+
+	t.Run("ok - within a transaction", func(t *testing.T) {
+		s := testStore(t)
+		_ = s.Collection("test").Add([]Document{jsonLdExample})
+
+		_ = s.View(func(tx ReadTx) error {
+			docs, err := tx.Collection("test").Find(context.Background(), nameQuery, WithCompaction(compactContext))
+			if assert.NoError(t, err) && assert.Len(t, docs, 1) {
+				assert.Contains(t, string(docs[0]), `"fullName":"Jane Doe"`)
+			}
+			return nil
+		})
+	})
+
+	t.Run("error - invalid context", func(t *testing.T) {
+		_, err := c.Find(context.Background(), nameQuery, WithCompaction(map[string]interface{}{"@context": 1}))
+
+		assert.Error(t, err)
+	})
+}
@@ -29,6 +29,8 @@ import (
 type queryPlan interface {
 	// execute the plan call the DocumentWalker for each matching document
 	execute(walker DocumentWalker) error
+	// executeTx is like execute but uses the given transaction
+	executeTx(tx *bbolt.Tx, walker DocumentWalker) error
 }
 
 // queryPlanBase contains elements common for each query plan
@@ -62,31 +64,30 @@ type documentScanFn func(key []byte, value []byte) error
 
 func (f fullTableScanQueryPlan) execute(walker DocumentWalker) error {
 	return f.collection.db.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte(f.collection.Name))
-		if bucket == nil {
-			// no bucket means no docs
-			return nil
-		}
-		bucket = bucket.Bucket(documentBucketByteRef())
-		if bucket == nil {
-			// no bucket means no docs
-			return nil
-		}
+		return f.executeTx(tx, walker)
+	})
+}
 
-		parts := make([]QueryPart, 0)
-		if f.query != nil {
-			parts = f.query.Parts()
-		}
-		scanner := resultScanner(parts, walker, f.collection)
+func (f fullTableScanQueryPlan) executeTx(tx *bbolt.Tx, walker DocumentWalker) error {
+	bucket := f.collection.documentBucket(tx)
+	if bucket == nil {
+		// no bucket means no docs
+		return nil
+	}
 
-		cursor := bucket.Cursor()
-		for ref, bytes := cursor.First(); bytes != nil; ref, bytes = cursor.Next() {
-			if err := scanner(ref, bytes); err != nil {
-				return err
-			}
+	parts := make([]QueryPart, 0)
+	if f.query != nil {
+		parts = f.query.Parts()
+	}
+	scanner := resultScanner(parts, walker, f.collection.expander(tx), f.collection.pathEvaluator(tx))
+
+	cursor := bucket.Cursor()
+	for ref, bytes := cursor.First(); bytes != nil; ref, bytes = cursor.Next() {
+		if err := scanner(ref, bytes); err != nil {
+			return err
 		}
-		return nil
-	})
+	}
+	return nil
 }
 
 func (i indexScanQueryPlan) execute(walker ReferenceScanFn) error {
@@ -98,7 +99,7 @@ func (i indexScanQueryPlan) execute(walker ReferenceScanFn) error {
 	// do the IndexScan
 	return i.collection.db.View(func(tx *bbolt.Tx) error {
 		// nil is not possible since adding an index creates the iBucket
-		iBucket := tx.Bucket([]byte(i.collection.Name))
+		iBucket := i.collection.bucket(tx)
 		if iBucket == nil { // nothing added yet
 			return nil
 		}
@@ -111,30 +112,40 @@ func (i indexScanQueryPlan) execute(walker ReferenceScanFn) error {
 }
 
 func (i resultScanQueryPlan) execute(walker DocumentWalker) error {
+	return i.collection.db.View(func(tx *bbolt.Tx) error {
+		return i.executeTx(tx, walker)
+	})
+}
+
+func (i resultScanQueryPlan) executeTx(tx *bbolt.Tx, walker DocumentWalker) error {
 	queryParts := i.index.QueryPartsOutsideIndex(i.query)
+	// the keys of the index don't have a type, the parts that depend on it are checked against the documents as well
+	for _, part := range i.query.Parts() {
+		if _, ok := part.(valueCondition); ok {
+			queryParts = append(queryParts, part)
+		}
+	}
 
 	// do the IndexScan
-	return i.collection.db.View(func(tx *bbolt.Tx) error {
-		docBucket := i.collection.documentBucket(tx)
-		if docBucket == nil {
-			// no bucket means no docs
-			return nil
-		}
+	docBucket := i.collection.documentBucket(tx)
+	if docBucket == nil {
+		// no bucket means no docs
+		return nil
+	}
 
-		// nil is not possible since adding an index creates the iBucket
-		iBucket := tx.Bucket([]byte(i.collection.Name))
+	// nil is not possible since adding an index creates the iBucket
+	iBucket := i.collection.bucket(tx)
 
-		// resultScanner takes the refs from the indexScan, resolves the document and applies the remaining queryParts
-		resultScan := resultScanner(queryParts, walker, i.collection)
+	// resultScanner takes the refs from the indexScan, resolves the document and applies the remaining queryParts
+	resultScan := resultScanner(queryParts, walker, i.collection.expander(tx), i.collection.pathEvaluator(tx))
 
-		// fetcher expands references to documents, for each document it calls the resultScan
-		fetcher := documentFetcher(docBucket, resultScan)
+	// fetcher expands references to documents, for each document it calls the resultScan
+	fetcher := documentFetcher(docBucket, resultScan)
 
-		// expander expands the index entry to the actual document
-		expander := indexEntryExpander(fetcher)
+	// expander expands the index entry to the actual document
+	expander := indexEntryExpander(fetcher)
 
-		return i.index.Iterate(iBucket, i.query, expander)
-	})
+	return i.index.Iterate(iBucket, i.query, expander)
 }
 
 // documentFetcher creates a ReferenceScanFn which is called with a reference, fetches the document and calls the documentScanFn
@@ -153,19 +164,29 @@ func documentFetcher(documentCollection *bbolt.Bucket, docWalker documentScanFn)
 }
 
 // resultScanner returns a resultScannerFn. For each call it will compare the document against the given queryParts.
-// If conditions are met, it'll call the DocumentWalker
-func resultScanner(queryParts []QueryPart, walker DocumentWalker, collection Collection) documentScanFn {
+// The expand func is only called when there are queryParts, once per document.
+// The evaluate func returns the values at the TermPath of a query part.
+func resultScanner(queryParts []QueryPart, walker DocumentWalker, expand func(ref Reference, doc Document) ([]interface{}, error), evaluate func(expanded []interface{}, termPath TermPath) ([]Scalar, error)) documentScanFn {
 	return func(ref []byte, doc []byte) error {
+		var expanded []interface{}
+		if len(queryParts) > 0 {
+			var err error
+			if expanded, err = expand(ref, doc); err != nil {
+				return err
+			}
+		}
 
 	outer:
 		for _, part := range queryParts {
-			keys, err := collection.ValuesAtPath(doc, part.TermPath())
-			if err != nil {
-				return err
+			var keys []Scalar
+			if !part.TermPath().IsEmpty() {
+				var err error
+				if keys, err = evaluate(expanded, part.TermPath()); err != nil {
+					return err
+				}
 			}
 			for _, k := range keys {
-				m := part.Condition(k.Bytes(), nil)
-				if m {
+				if conditionOnValue(part, k) {
 					continue outer
 				}
 			}
@@ -0,0 +1,311 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrInvalidQueryJSON is returned when the JSON of a query or query part can't be unmarshalled
+var ErrInvalidQueryJSON = errors.New("invalid query JSON")
+
+// ErrUnknownQueryPart is returned when a query part type is not registered
+var ErrUnknownQueryPart = errors.New("unknown query part type")
+
+// ErrQueryPartRegistered is returned when a query part type name is registered twice
+var ErrQueryPartRegistered = errors.New("query part type already registered")
+
+const (
+	andPartType    = "and"
+	eqPartType     = "eq"
+	rangePartType  = "range"
+	prefixPartType = "prefix"
+)
+
+// boundPartTypes are the types of the query parts created by GreaterThan, GreaterThanOrEqual, LessThan and LessThanOrEqual, by operator
+var boundPartTypes = map[string]string{
+	">":  "gt",
+	">=": "gte",
+	"<":  "lt",
+	"<=": "lte",
+}
+
+// boundOperator returns the operator of a query part type in boundPartTypes
+func boundOperator(partType string) (string, bool) {
+	for operator, t := range boundPartTypes {
+		if t == partType {
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+// queryPartTypes contains the registered custom query part types
+var queryPartTypes = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{
+	byName: map[string]reflect.Type{},
+	byType: map[reflect.Type]string{},
+}
+
+// RegisterQueryPart registers a custom QueryPart type under the given name, so it can be marshalled to and from JSON.
+// The part is marshalled with encoding/json and must result in a JSON object, the "type" member is added with the name.
+// It is unmarshalled into a new value of the same type as the prototype.
+// The names of the built-in types ("and", "eq", "range", "prefix", "gt", "gte", "lt" and "lte") can't be used.
+func RegisterQueryPart(name string, prototype QueryPart) error {
+	if prototype == nil || name == "" {
+		return errors.New("name and prototype are required")
+	}
+	_, isBound := boundOperator(name)
+	switch {
+	case isBound, name == andPartType, name == eqPartType, name == rangePartType, name == prefixPartType:
+		return fmt.Errorf("%w: %s", ErrQueryPartRegistered, name)
+	}
+	queryPartTypes.Lock()
+	defer queryPartTypes.Unlock()
+
+	t := reflect.TypeOf(prototype)
+	if _, ok := queryPartTypes.byName[name]; ok {
+		return fmt.Errorf("%w: %s", ErrQueryPartRegistered, name)
+	}
+	if _, ok := queryPartTypes.byType[t]; ok {
+		return fmt.Errorf("%w: %s", ErrQueryPartRegistered, t)
+	}
+	queryPartTypes.byName[name] = t
+	queryPartTypes.byType[t] = name
+	return nil
+}
+
+// queryPartJSON is the JSON representation of the built-in query parts and of the query itself
+type queryPartJSON struct {
+	Type  string            `json:"type"`
+	Path  *TermPath         `json:"path,omitempty"`
+	Value *Scalar           `json:"value,omitempty"`
+	Begin *Scalar           `json:"begin,omitempty"`
+	End   *Scalar           `json:"end,omitempty"`
+	Parts []json.RawMessage `json:"parts,omitempty"`
+}
+
+// MarshalQuery returns the JSON representation of the query, an object with all parts:
+//
+//	{"type": "and", "parts": [
+//	  {"type": "eq", "path": ["http://schema.org/name"], "value": "Jane Doe"},
+//	  {"type": "range", "path": ["http://schema.org/weight"], "begin": 70, "end": 90},
+//	  {"type": "prefix", "path": ["http://schema.org/children", "http://schema.org/name"], "value": "Jo"},
+//	  {"type": "gt", "path": ["http://schema.org/weight"], "value": 80}
+//	]}
+//
+// The comparisons have the types "gt", "gte", "lt" and "lte". A TermPath is an array of terms and values are JSON strings, numbers or booleans, like the Scalar they represent.
+func MarshalQuery(query Query) ([]byte, error) {
+	result := queryPartJSON{Type: andPartType}
+	for _, part := range query.Parts() {
+		data, err := MarshalQueryPart(part)
+		if err != nil {
+			return nil, err
+		}
+		result.Parts = append(result.Parts, data)
+	}
+	return json.Marshal(result)
+}
+
+// UnmarshalQuery creates a query from its JSON representation as returned by MarshalQuery.
+// Query parts of type "and" may be nested, their parts are added to the query. A single query part is also accepted.
+// It returns an error wrapping ErrInvalidQueryJSON or ErrUnknownQueryPart when the JSON is invalid.
+func UnmarshalQuery(data []byte) (Query, error) {
+	parts, err := unmarshalQueryParts(data)
+	if err != nil {
+		return nil, err
+	}
+	result := New(parts[0])
+	for _, part := range parts[1:] {
+		result = result.And(part)
+	}
+	return result, nil
+}
+
+// MarshalQueryPart returns the JSON representation of a query part, see MarshalQuery.
+// It returns ErrUnknownQueryPart when the part is not built-in nor registered with RegisterQueryPart.
+func MarshalQueryPart(part QueryPart) ([]byte, error) {
+	switch p := part.(type) {
+	case eqPart:
+		return json.Marshal(queryPartJSON{Type: eqPartType, Path: &p.termPath, Value: &p.value})
+	case rangePart:
+		return json.Marshal(queryPartJSON{Type: rangePartType, Path: &p.termPath, Begin: &p.begin, End: &p.end})
+	case prefixPart:
+		return json.Marshal(queryPartJSON{Type: prefixPartType, Path: &p.termPath, Value: &p.value})
+	case boundPart:
+		return json.Marshal(queryPartJSON{Type: boundPartTypes[p.operator()], Path: &p.termPath, Value: &p.value})
+	}
+
+	queryPartTypes.RLock()
+	name, ok := queryPartTypes.byType[reflect.TypeOf(part)]
+	queryPartTypes.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnknownQueryPart, part)
+	}
+
+	data, err := json.Marshal(part)
+	if err != nil {
+		return nil, err
+	}
+	members := map[string]json.RawMessage{}
+	if err = json.Unmarshal(data, &members); err != nil {
+		return nil, fmt.Errorf("query part of type %s doesn't marshal to a JSON object: %w", name, err)
+	}
+	members["type"], _ = json.Marshal(name)
+	return json.Marshal(members)
+}
+
+// UnmarshalQueryPart creates a query part from its JSON representation as returned by MarshalQueryPart.
+func UnmarshalQueryPart(data []byte) (QueryPart, error) {
+	parts, err := unmarshalQueryParts(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) != 1 {
+		return nil, fmt.Errorf("%w: expected a single query part, found %d", ErrInvalidQueryJSON, len(parts))
+	}
+	return parts[0], nil
+}
+
+// unmarshalQueryParts returns the query parts of the JSON, the parts of "and" are flattened
+func unmarshalQueryParts(data []byte) ([]QueryPart, error) {
+	var typed struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidQueryJSON, err)
+	}
+
+	_, isBound := boundOperator(typed.Type)
+	switch {
+	case typed.Type == "":
+		return nil, fmt.Errorf("%w: missing type", ErrInvalidQueryJSON)
+	case isBound, typed.Type == andPartType, typed.Type == eqPartType, typed.Type == rangePartType, typed.Type == prefixPartType:
+		return unmarshalBuiltinQueryPart(typed.Type, data)
+	}
+
+	queryPartTypes.RLock()
+	t, ok := queryPartTypes.byName[typed.Type]
+	queryPartTypes.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownQueryPart, typed.Type)
+	}
+
+	var target reflect.Value
+	if t.Kind() == reflect.Ptr {
+		target = reflect.New(t.Elem())
+	} else {
+		target = reflect.New(t)
+	}
+	if err := json.Unmarshal(data, target.Interface()); err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidQueryJSON, typed.Type, err)
+	}
+	if t.Kind() != reflect.Ptr {
+		target = target.Elem()
+	}
+	return []QueryPart{target.Interface().(QueryPart)}, nil
+}
+
+func unmarshalBuiltinQueryPart(partType string, data []byte) ([]QueryPart, error) {
+	var p queryPartJSON
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidQueryJSON, partType, err)
+	}
+	missing := func(member string) error {
+		return fmt.Errorf("%w: %s: missing %s", ErrInvalidQueryJSON, partType, member)
+	}
+
+	if partType == andPartType {
+		if len(p.Parts) == 0 {
+			return nil, missing("parts")
+		}
+		var parts []QueryPart
+		for _, partData := range p.Parts {
+			nested, err := unmarshalQueryParts(partData)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, nested...)
+		}
+		return parts, nil
+	}
+
+	if p.Path == nil || p.Path.IsEmpty() {
+		return nil, missing("path")
+	}
+	switch partType {
+	case rangePartType:
+		if p.Begin == nil {
+			return nil, missing("begin")
+		}
+		if p.End == nil {
+			return nil, missing("end")
+		}
+		return []QueryPart{Range(*p.Path, *p.Begin, *p.End)}, nil
+	case eqPartType:
+		if p.Value == nil {
+			return nil, missing("value")
+		}
+		return []QueryPart{Eq(*p.Path, *p.Value)}, nil
+	case prefixPartType:
+		if p.Value == nil {
+			return nil, missing("value")
+		}
+		return []QueryPart{Prefix(*p.Path, *p.Value)}, nil
+	default:
+		if p.Value == nil {
+			return nil, missing("value")
+		}
+		operator, _ := boundOperator(partType)
+		return []QueryPart{boundOperators[operator](*p.Path, *p.Value)}, nil
+	}
+}
+
+// MarshalJSON marshals the query, see MarshalQuery
+func (q query) MarshalJSON() ([]byte, error) {
+	return MarshalQuery(q)
+}
+
+// MarshalJSON marshals the query part, see MarshalQueryPart
+func (e eqPart) MarshalJSON() ([]byte, error) {
+	return MarshalQueryPart(e)
+}
+
+// MarshalJSON marshals the query part, see MarshalQueryPart
+func (r rangePart) MarshalJSON() ([]byte, error) {
+	return MarshalQueryPart(r)
+}
+
+// MarshalJSON marshals the query part, see MarshalQueryPart
+func (p prefixPart) MarshalJSON() ([]byte, error) {
+	return MarshalQueryPart(p)
+}
+
+// MarshalJSON marshals the query part, see MarshalQueryPart
+func (b boundPart) MarshalJSON() ([]byte, error) {
+	return MarshalQueryPart(b)
+}
@@ -0,0 +1,215 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lengthPart is a custom query part that matches values of an exact length
+type lengthPart struct {
+	Path   TermPath `json:"path"`
+	Length int      `json:"length"`
+}
+
+func (l lengthPart) Equals(other IRIComparable) bool {
+	return l.Path.Equals(other.TermPath())
+}
+
+func (l lengthPart) TermPath() TermPath {
+	return l.Path
+}
+
+func (l lengthPart) Seek() Scalar {
+	return ScalarMustParse("")
+}
+
+func (l lengthPart) Condition(key Key, _ Transform) bool {
+	return len(bytes.TrimRight(key, "\x00")) == l.Length
+}
+
+func init() {
+	if err := RegisterQueryPart("length", lengthPart{}); err != nil {
+		panic(err)
+	}
+}
+
+func TestMarshalQuery(t *testing.T) {
+	name := NewTermPath("http://schema.org/name")
+
+	t.Run("ok - documented format", func(t *testing.T) {
+		q := New(Eq(name, ScalarMustParse("Jane Doe"))).
+			And(Range(NewTermPath("http://schema.org/weight"), ScalarMustParse(70.0), ScalarMustParse(90.0))).
+			And(Prefix(NewTermPath("http://schema.org/children", "http://schema.org/name"), ScalarMustParse("Jo"))).
+			And(GreaterThan(NewTermPath("http://schema.org/weight"), ScalarMustParse(80.0)))
+
+		data, err := json.Marshal(q)
+
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type": "and", "parts": [
+  {"type": "eq", "path": ["http://schema.org/name"], "value": "Jane Doe"},
+  {"type": "range", "path": ["http://schema.org/weight"], "begin": 70, "end": 90},
+  {"type": "prefix", "path": ["http://schema.org/children", "http://schema.org/name"], "value": "Jo"},
+  {"type": "gt", "path": ["http://schema.org/weight"], "value": 80}
+]}`, string(data))
+	})
+
+	t.Run("ok - round trip", func(t *testing.T) {
+		q := New(IsType("http://schema.org/Person")).
+			And(Eq(NewTermPath(GraphTerm("http://example.com/g"), "http://schema.org/active"), ScalarMustParse(true))).
+			And(Range(NewTermPath("http://schema.org/weight"), ScalarMustParse(70.5), ScalarMustParse(-1.0))).
+			And(GreaterThanOrEqual(NewTermPath("http://schema.org/weight"), ScalarMustParse(1.0))).
+			And(LessThan(name, ScalarMustParse("b"))).
+			And(LessThanOrEqual(name, ScalarMustParse("a"))).
+			And(lengthPart{Path: name, Length: 8})
+
+		data, err := MarshalQuery(q)
+		if !assert.NoError(t, err) {
+			return
+		}
+		unmarshalled, err := UnmarshalQuery(data)
+
+		assert.NoError(t, err)
+		assert.Equal(t, q, unmarshalled)
+	})
+
+	t.Run("ok - custom part", func(t *testing.T) {
+		data, err := MarshalQueryPart(lengthPart{Path: name, Length: 8})
+
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type": "length", "path": ["http://schema.org/name"], "length": 8}`, string(data))
+	})
+
+	t.Run("ok - custom part in a query", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.AddIndex(c.NewIndex("name", NewFieldIndexer(name)))
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		q, _ := UnmarshalQuery([]byte(`{"type": "length", "path": ["http://schema.org/name"], "length": 8}`))
+
+		docs, err := c.Find(context.Background(), q)
+
+		assert.NoError(t, err)
+		assert.Len(t, docs, 2)
+	})
+
+	t.Run("error - unregistered part", func(t *testing.T) {
+		_, err := MarshalQuery(New(testQueryPart{}))
+
+		assert.True(t, errors.Is(err, ErrUnknownQueryPart))
+	})
+}
+
+func TestUnmarshalQuery(t *testing.T) {
+	t.Run("ok - nested and single parts", func(t *testing.T) {
+		q, err := UnmarshalQuery([]byte(`{"type": "and", "parts": [
+  {"type": "eq", "path": ["@type"], "value": "http://schema.org/Person"},
+  {"type": "and", "parts": [{"type": "prefix", "path": ["http://schema.org/name"], "value": "J"}]}
+]}`))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, []QueryPart{IsType("http://schema.org/Person"), Prefix(NewTermPath("http://schema.org/name"), ScalarMustParse("J"))}, q.Parts())
+
+		q, err = UnmarshalQuery([]byte(`{"type": "eq", "path": ["@type"], "value": "http://schema.org/Person"}`))
+
+		assert.NoError(t, err)
+		assert.Equal(t, New(IsType("http://schema.org/Person")), q)
+	})
+
+	errorCases := []struct {
+		name     string
+		json     string
+		sentinel error
+		message  string
+	}{
+		{"not JSON", `{`, ErrInvalidQueryJSON, "invalid query JSON: unexpected end of JSON input"},
+		{"no type", `{}`, ErrInvalidQueryJSON, "invalid query JSON: missing type"},
+		{"unknown type", `{"type": "or"}`, ErrUnknownQueryPart, "unknown query part type: or"},
+		{"empty and", `{"type": "and", "parts": []}`, ErrInvalidQueryJSON, "invalid query JSON: and: missing parts"},
+		{"no path", `{"type": "eq", "value": 1}`, ErrInvalidQueryJSON, "invalid query JSON: eq: missing path"},
+		{"no value", `{"type": "prefix", "path": ["a"]}`, ErrInvalidQueryJSON, "invalid query JSON: prefix: missing value"},
+		{"no end", `{"type": "range", "path": ["a"], "begin": 1}`, ErrInvalidQueryJSON, "invalid query JSON: range: missing end"},
+		{"invalid value", `{"type": "eq", "path": ["a"], "value": [1]}`, ErrInvalidQueryJSON, "invalid query JSON: eq: invalid value"},
+		{"invalid nested part", `{"type": "and", "parts": [{"type": "eq"}]}`, ErrInvalidQueryJSON, "invalid query JSON: eq: missing path"},
+		{"invalid custom part", `{"type": "length", "length": "8"}`, ErrInvalidQueryJSON, "invalid query JSON: length: json: cannot unmarshal string into Go struct field lengthPart.length of type int"},
+	}
+	for _, c := range errorCases {
+		t.Run("error - "+c.name, func(t *testing.T) {
+			_, err := UnmarshalQuery([]byte(c.json))
+
+			assert.True(t, errors.Is(err, c.sentinel))
+			assert.EqualError(t, err, c.message)
+		})
+	}
+}
+
+func TestUnmarshalQueryPart(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		part, err := UnmarshalQueryPart([]byte(`{"type": "range", "path": ["a"], "begin": "a", "end": "b"}`))
+
+		assert.NoError(t, err)
+		assert.Equal(t, Range(NewTermPath("a"), ScalarMustParse("a"), ScalarMustParse("b")), part)
+	})
+
+	t.Run("error - multiple parts", func(t *testing.T) {
+		_, err := UnmarshalQueryPart([]byte(`{"type": "and", "parts": [{"type": "eq", "path": ["a"], "value": 1}, {"type": "eq", "path": ["b"], "value": 2}]}`))
+
+		assert.True(t, errors.Is(err, ErrInvalidQueryJSON))
+	})
+}
+
+func TestRegisterQueryPart(t *testing.T) {
+	t.Run("error - built-in name", func(t *testing.T) {
+		err := RegisterQueryPart("eq", testQueryPart{})
+
+		assert.True(t, errors.Is(err, ErrQueryPartRegistered))
+	})
+
+	t.Run("error - built-in comparison name", func(t *testing.T) {
+		err := RegisterQueryPart("lte", testQueryPart{})
+
+		assert.True(t, errors.Is(err, ErrQueryPartRegistered))
+	})
+
+	t.Run("error - name already registered", func(t *testing.T) {
+		err := RegisterQueryPart("length", testQueryPart{})
+
+		assert.True(t, errors.Is(err, ErrQueryPartRegistered))
+	})
+
+	t.Run("error - type already registered", func(t *testing.T) {
+		err := RegisterQueryPart("other", lengthPart{})
+
+		assert.True(t, errors.Is(err, ErrQueryPartRegistered))
+	})
+
+	t.Run("error - no prototype", func(t *testing.T) {
+		err := RegisterQueryPart("other", nil)
+
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,400 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidQuery is returned when a textual query can't be parsed
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrUnsupportedQueryPart is returned when a query part can't be formatted
+var ErrUnsupportedQueryPart = errors.New("unsupported query part")
+
+// keywordTerms are the special terms that are written as JSON-LD keywords, by keyword without '@'
+var keywordTerms = map[string]string{
+	"id":          IDTerm,
+	"type":        TypeTerm,
+	"graphs":      AllGraphsTerm,
+	"dereference": DereferenceTerm,
+}
+
+// functionTerms create the special terms that are written as a function of an IRI, by function name
+var functionTerms = map[string]func(iri string) string{
+	"type":    TypeFilterTerm,
+	"id":      IDFilterTerm,
+	"graph":   GraphTerm,
+	"reverse": ReverseTerm,
+}
+
+// boundOperators create the query parts of the comparison operators, by operator
+var boundOperators = map[string]func(termPath TermPath, value Scalar) QueryPart{
+	">":  GreaterThan,
+	">=": GreaterThanOrEqual,
+	"<":  LessThan,
+	"<=": LessThanOrEqual,
+}
+
+// ParseQuery parses a query in the textual query language:
+//
+//	PREFIX schema: <http://schema.org/>
+//	schema:name = "Jane Doe" AND schema:children/schema:name STARTS WITH "Jo" AND schema:weight BETWEEN 70 AND 90
+//	schema:name = "Jane" AND schema:weight > 80
+//
+// A condition consists of a TermPath, an operator and its values, conditions are combined with AND.
+// The terms of a TermPath are separated by '/'. A term is an IRI between angle brackets, a compact IRI with a declared prefix
+// or a special term: * (WildcardTerm), ** (DescendantTerm), @id, @type, @graphs, @dereference,
+// type(iri), id(iri), graph(iri) and reverse(iri) (TypeFilterTerm, IDFilterTerm, GraphTerm and ReverseTerm).
+// The operators are = (Eq), BETWEEN a AND b (Range, both inclusive), STARTS WITH (Prefix),
+// > (GreaterThan), >= (GreaterThanOrEqual), < (LessThan) and <= (LessThanOrEqual), which only match values of the same type.
+// Values are strings between double or single quotes, numbers, true, false or IRIs, which are matched as strings.
+// Keywords are case-insensitive. Errors wrap ErrInvalidQuery and contain the line and column of the offending token.
+func ParseQuery(text string) (Query, error) {
+	tp, err := newTokenParser(text, ErrInvalidQuery)
+	if err != nil {
+		return nil, err
+	}
+	p := queryParser{tokenParser: tp}
+	return p.parseQuery()
+}
+
+// queryParser parses the tokens of a textual query
+type queryParser struct {
+	*tokenParser
+}
+
+func (p *queryParser) parseQuery() (Query, error) {
+	if err := p.parsePrefixes(); err != nil {
+		return nil, err
+	}
+
+	var query Query
+	for {
+		part, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		if query == nil {
+			query = New(part)
+		} else {
+			query = query.And(part)
+		}
+
+		if p.acceptKeyword("AND") {
+			continue
+		}
+		if token := p.peek(); token.kind != tokenEOF {
+			return nil, p.unexpected(token, "AND or end of query")
+		}
+		return query, nil
+	}
+}
+
+func (p *queryParser) parseCondition() (QueryPart, error) {
+	termPath, err := p.parseTermPath()
+	if err != nil {
+		return nil, err
+	}
+
+	token := p.peek()
+	switch {
+	case p.acceptPunctuation("="):
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return Eq(termPath, value), nil
+	case p.acceptKeyword("BETWEEN"):
+		begin, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		end, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return Range(termPath, begin, end), nil
+	case p.acceptKeyword("STARTS"):
+		if err = p.expectKeyword("WITH"); err != nil {
+			return nil, err
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return Prefix(termPath, value), nil
+	case token.kind == tokenPunctuation && boundOperators[token.text] != nil:
+		p.next()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return boundOperators[token.text](termPath, value), nil
+	case token.kind == tokenPunctuation && token.text == "!=":
+		return nil, p.errorf(token, "operator %s is not supported", token.text)
+	}
+	return nil, p.unexpected(token, "=, BETWEEN, STARTS WITH or a comparison operator")
+}
+
+func (p *queryParser) parseTermPath() (TermPath, error) {
+	var terms []string
+	for {
+		term, err := p.parseTerm()
+		if err != nil {
+			return TermPath{}, err
+		}
+		terms = append(terms, term)
+		if !p.acceptPunctuation("/") {
+			return NewTermPath(terms...), nil
+		}
+	}
+}
+
+func (p *queryParser) parseTerm() (string, error) {
+	token := p.peek()
+	switch token.kind {
+	case tokenIRI, tokenPrefixedName:
+		return p.parseIRI()
+	case tokenAtWord:
+		p.next()
+		term, ok := keywordTerms[token.text]
+		if !ok {
+			return "", p.errorf(token, "unknown term @%s", token.text)
+		}
+		return term, nil
+	case tokenWord:
+		function, ok := functionTerms[token.text]
+		if !ok {
+			break
+		}
+		p.next()
+		if err := p.expectPunctuation("("); err != nil {
+			return "", err
+		}
+		iri, err := p.parseIRI()
+		if err != nil {
+			return "", err
+		}
+		return function(iri), p.expectPunctuation(")")
+	case tokenPunctuation:
+		switch {
+		case p.acceptPunctuation("*"):
+			return WildcardTerm, nil
+		case p.acceptPunctuation("**"):
+			return DescendantTerm, nil
+		}
+	}
+	return "", p.unexpected(token, "a term")
+}
+
+func (p *queryParser) parseValue() (Scalar, error) {
+	token := p.peek()
+	switch {
+	case token.kind == tokenString:
+		p.next()
+		return ScalarMustParse(token.text), nil
+	case token.kind == tokenNumber:
+		p.next()
+		f, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return Scalar{}, p.errorf(token, "invalid number %q", token.text)
+		}
+		return ScalarMustParse(f), nil
+	case token.kind == tokenWord && (token.text == "true" || token.text == "false"):
+		p.next()
+		return ScalarMustParse(token.text == "true"), nil
+	case token.kind == tokenIRI || token.kind == tokenPrefixedName:
+		iri, err := p.parseIRI()
+		if err != nil {
+			return Scalar{}, err
+		}
+		return ScalarMustParse(iri), nil
+	}
+	return Scalar{}, p.unexpected(token, "a value")
+}
+
+// FormatQuery formats the query in the textual query language, ParseQuery returns an equivalent query.
+// IRIs in term paths are compacted with the given prefixes, the prefixes that are used are declared.
+// It returns ErrUnsupportedQueryPart for query parts that are not created by Eq, Range, Prefix, the comparisons or their helpers.
+func FormatQuery(query Query, prefixes map[string]string) (string, error) {
+	f := queryFormatter{prefixes: prefixes, used: map[string]bool{}}
+	conditions := make([]string, len(query.Parts()))
+	for j, part := range query.Parts() {
+		var err error
+		if conditions[j], err = f.formatPart(part); err != nil {
+			return "", err
+		}
+	}
+
+	builder := strings.Builder{}
+	used := make([]string, 0, len(f.used))
+	for name := range f.used {
+		used = append(used, name)
+	}
+	sort.Strings(used)
+	for _, name := range used {
+		builder.WriteString(fmt.Sprintf("PREFIX %s: <%s>\n", name, prefixes[name]))
+	}
+	builder.WriteString(strings.Join(conditions, " AND "))
+	return builder.String(), nil
+}
+
+type queryFormatter struct {
+	prefixes map[string]string
+	// used contains the names of the prefixes used so far
+	used map[string]bool
+}
+
+func (f queryFormatter) formatPart(part QueryPart) (string, error) {
+	termPath, err := f.formatTermPath(part.TermPath())
+	if err != nil {
+		return "", err
+	}
+
+	switch p := part.(type) {
+	case eqPart:
+		value, err := formatValue(p.value)
+		return termPath + " = " + value, err
+	case prefixPart:
+		value, err := formatValue(p.value)
+		return termPath + " STARTS WITH " + value, err
+	case rangePart:
+		begin, err := formatValue(p.begin)
+		if err != nil {
+			return "", err
+		}
+		end, err := formatValue(p.end)
+		return termPath + " BETWEEN " + begin + " AND " + end, err
+	case boundPart:
+		value, err := formatValue(p.value)
+		return termPath + " " + p.operator() + " " + value, err
+	}
+	return "", fmt.Errorf("%w: %T", ErrUnsupportedQueryPart, part)
+}
+
+func (f queryFormatter) formatTermPath(termPath TermPath) (string, error) {
+	if termPath.IsEmpty() {
+		return "", fmt.Errorf("%w: empty TermPath", ErrUnsupportedQueryPart)
+	}
+	terms := make([]string, len(termPath.Terms))
+	for j, term := range termPath.Terms {
+		var err error
+		if terms[j], err = f.formatTerm(term); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(terms, "/"), nil
+}
+
+func (f queryFormatter) formatTerm(term string) (string, error) {
+	switch term {
+	case WildcardTerm:
+		return "*", nil
+	case DescendantTerm:
+		return "**", nil
+	}
+	for keyword, keywordTerm := range keywordTerms {
+		if term == keywordTerm {
+			return "@" + keyword, nil
+		}
+	}
+
+	for _, function := range []struct {
+		name    string
+		fromIRI func(term string) (string, bool)
+	}{{"type", typeFromFilterTerm}, {"id", idFromFilterTerm}, {"graph", graphFromTerm}, {"reverse", reverseFromTerm}} {
+		if iri, ok := function.fromIRI(term); ok {
+			formatted, err := f.formatIRI(iri)
+			return function.name + "(" + formatted + ")", err
+		}
+	}
+	return f.formatIRI(term)
+}
+
+// formatIRI returns a compact IRI with the prefix with the longest matching namespace or the full IRI between angle brackets
+func (f queryFormatter) formatIRI(iri string) (string, error) {
+	best := ""
+	for name, namespace := range f.prefixes {
+		if !strings.HasPrefix(iri, namespace) || !isPrefixName(name) || !isLocalName(iri[len(namespace):]) {
+			continue
+		}
+		if best == "" || len(namespace) > len(f.prefixes[best]) || (len(namespace) == len(f.prefixes[best]) && name < best) {
+			best = name
+		}
+	}
+	if best != "" {
+		f.used[best] = true
+		return best + ":" + iri[len(f.prefixes[best]):], nil
+	}
+
+	if strings.Contains(iri, ">") || !isIRIRef("<"+iri+">") {
+		return "", fmt.Errorf("%w: IRI %q can't be formatted", ErrUnsupportedQueryPart, iri)
+	}
+	return "<" + iri + ">", nil
+}
+
+func isPrefixName(name string) bool {
+	for j := 0; j < len(name); j++ {
+		if c := rune(name[j]); !isNameChar(c) || (j == 0 && !isLetter(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isLocalName returns true if the local part of a compact IRI is lexed as part of the same token
+func isLocalName(local string) bool {
+	for j := 0; j < len(local); j++ {
+		if c := rune(local[j]); !isNameChar(c) && c != ':' && c != '.' && c != '-' {
+			return false
+		}
+	}
+	return !strings.HasSuffix(local, ".")
+}
+
+func formatValue(value Scalar) (string, error) {
+	switch v := value.value.(type) {
+	case string:
+		return quoteString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "", fmt.Errorf("%w: number %v can't be formatted", ErrUnsupportedQueryPart, v)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("%w: value of type %T", ErrUnsupportedQueryPart, value.value)
+}
+
+// quoteString quotes the string with the escape sequences supported by the lexer
+func quoteString(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+	return `"` + replacer.Replace(s) + `"`
+}
@@ -0,0 +1,243 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuery(t *testing.T) {
+	t.Run("ok - conditions", func(t *testing.T) {
+		q, err := ParseQuery(`PREFIX schema: <http://schema.org/>
+schema:name = "Jane Doe" and schema:children/schema:name STARTS WITH 'Jo'
+AND schema:weight BETWEEN 70 AND 90.5`)
+
+		if !assert.NoError(t, err) || !assert.Len(t, q.Parts(), 3) {
+			return
+		}
+		assert.Equal(t, Eq(NewTermPath("http://schema.org/name"), ScalarMustParse("Jane Doe")), q.Parts()[0])
+		assert.Equal(t, Prefix(NewTermPath("http://schema.org/children", "http://schema.org/name"), ScalarMustParse("Jo")), q.Parts()[1])
+		assert.Equal(t, Range(NewTermPath("http://schema.org/weight"), ScalarMustParse(70.0), ScalarMustParse(90.5)), q.Parts()[2])
+	})
+
+	t.Run("ok - special terms", func(t *testing.T) {
+		q, err := ParseQuery(`PREFIX s: <http://schema.org/>
+@type = s:Person AND */**/@id = <http://example.com/1> AND @graphs/type(s:Person)/id(<http://example.com/2>)/graph(s:g)/reverse(s:parent)/@dereference/s:name = true`)
+
+		if !assert.NoError(t, err) || !assert.Len(t, q.Parts(), 3) {
+			return
+		}
+		assert.Equal(t, IsType("http://schema.org/Person"), q.Parts()[0])
+		assert.Equal(t, Eq(NewTermPath(WildcardTerm, DescendantTerm, IDTerm), ScalarMustParse("http://example.com/1")), q.Parts()[1])
+		expected := NewTermPath(AllGraphsTerm, TypeFilterTerm("http://schema.org/Person"), IDFilterTerm("http://example.com/2"),
+			GraphTerm("http://schema.org/g"), ReverseTerm("http://schema.org/parent"), DereferenceTerm, "http://schema.org/name")
+		assert.True(t, q.Parts()[2].TermPath().Equals(expected))
+		assert.Equal(t, ScalarMustParse(true), q.Parts()[2].Seek())
+	})
+
+	t.Run("ok - usable as query", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		q, _ := ParseQuery(`PREFIX schema: <http://schema.org/>
+schema:name STARTS WITH "J" AND schema:weight BETWEEN 70 AND 80`)
+
+		docs, err := c.Find(context.Background(), q)
+
+		assert.NoError(t, err)
+		assert.Len(t, docs, 1)
+	})
+
+	t.Run("ok - comparisons", func(t *testing.T) {
+		q, err := ParseQuery(`PREFIX schema: <http://schema.org/>
+schema:name = "Jane" AND schema:weight > 80 AND schema:weight >= 1 AND schema:height < 2 AND schema:height <= 'b'`)
+
+		if !assert.NoError(t, err) || !assert.Len(t, q.Parts(), 5) {
+			return
+		}
+		weight, height := NewTermPath("http://schema.org/weight"), NewTermPath("http://schema.org/height")
+		assert.Equal(t, Eq(NewTermPath("http://schema.org/name"), ScalarMustParse("Jane")), q.Parts()[0])
+		assert.Equal(t, GreaterThan(weight, ScalarMustParse(80.0)), q.Parts()[1])
+		assert.Equal(t, GreaterThanOrEqual(weight, ScalarMustParse(1.0)), q.Parts()[2])
+		assert.Equal(t, LessThan(height, ScalarMustParse(2.0)), q.Parts()[3])
+		assert.Equal(t, LessThanOrEqual(height, ScalarMustParse("b")), q.Parts()[4])
+	})
+
+	t.Run("ok - comparison usable as query", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.AddIndex(c.NewIndex("weight", NewFieldIndexer(NewTermPath("http://schema.org/weight"))))
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		q, _ := ParseQuery(`PREFIX schema: <http://schema.org/>
+schema:name = "John Doe" AND schema:weight > 80`)
+
+		docs, err := c.Find(context.Background(), q)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []Document{jsonLdExample2}, docs)
+	})
+
+	t.Run("ok - comparisons only match numbers", func(t *testing.T) {
+		var docs []Document
+		for _, weight := range []string{"70", "80", "90", "-5", `"heavy"`, `"heavyish"`} {
+			docs = append(docs, Document(`{"@context": {"@vocab": "http://schema.org/"}, "weight": `+weight+`}`))
+		}
+		docs = append(docs, Document(`{"@context": {"@vocab": "http://schema.org/"}, "name": "none"}`))
+		expectations := map[string][]Document{
+			"weight > 80":                         {docs[2]},
+			"weight >= 80":                        {docs[1], docs[2]},
+			"weight < 80":                         {docs[0], docs[3]},
+			"weight <= 80":                        {docs[0], docs[1], docs[3]},
+			"weight > -10":                        {docs[0], docs[1], docs[2], docs[3]},
+			"weight < -1":                         {docs[3]},
+			"weight >= 70 AND schema:weight < 90": {docs[0], docs[1]},
+			`weight > "a"`:                        {docs[4], docs[5]},
+			`weight < "heavyz"`:                   {docs[4], docs[5]},
+		}
+
+		for name, indexed := range map[string]bool{"indexed": true, "not indexed": false} {
+			c := createCollection(testDB(t))
+			if indexed {
+				_ = c.AddIndex(c.NewIndex("weight", NewFieldIndexer(NewTermPath("http://schema.org/weight"))))
+			}
+			_ = c.Add(docs)
+
+			for query, expected := range expectations {
+				q, err := ParseQuery("PREFIX schema: <http://schema.org/>\nschema:" + query)
+				if !assert.NoError(t, err, query) {
+					return
+				}
+
+				found, err := c.Find(context.Background(), q)
+
+				assert.NoError(t, err, name+": "+query)
+				assert.ElementsMatch(t, expected, found, name+": "+query)
+			}
+		}
+	})
+
+	errorCases := []struct {
+		name    string
+		query   string
+		message string
+	}{
+		{"empty", "", "line 1, column 1: expected a term, found end of query"},
+		{"unknown prefix", "foaf:name = 1", `line 1, column 1: unknown prefix "foaf"`},
+		{"unsupported operator", "<http://schema.org/weight> != 80", "line 1, column 28: operator != is not supported"},
+		{"missing operator", "<http://schema.org/name>\n\"Jane\"", `line 2, column 1: expected =, BETWEEN, STARTS WITH or a comparison operator, found "\"Jane\""`},
+		{"comparison without value", "<http://schema.org/weight> >= AND", `line 1, column 31: expected a value, found "AND"`},
+		{"missing value", "<http://schema.org/name> = AND", `line 1, column 28: expected a value, found "AND"`},
+		{"incomplete range", "<http://schema.org/weight> BETWEEN 1 OR 2", `line 1, column 38: expected AND, found "OR"`},
+		{"STARTS without WITH", "<http://schema.org/name> STARTS 'J'", `line 1, column 33: expected WITH, found "'J'"`},
+		{"OR", "<http://schema.org/a> = 1 OR <http://schema.org/b> = 2", `line 1, column 27: expected AND or end of query, found "OR"`},
+		{"unknown keyword", "@value = 1", "line 1, column 1: unknown term @value"},
+		{"unclosed function", "type(<http://schema.org/Person> = 1", `line 1, column 33: expected ")", found "="`},
+		{"unterminated path", "<http://schema.org/a>/ = 1", `line 1, column 24: expected a term, found "="`},
+		{"unexpected character", "<http://schema.org/a> = 1 & 2", "line 1, column 27: unexpected character '&'"},
+	}
+	for _, c := range errorCases {
+		t.Run("error - "+c.name, func(t *testing.T) {
+			_, err := ParseQuery(c.query)
+
+			assert.True(t, errors.Is(err, ErrInvalidQuery))
+			assert.EqualError(t, err, "invalid query: "+c.message)
+		})
+	}
+}
+
+func TestFormatQuery(t *testing.T) {
+	prefixes := map[string]string{
+		"schema": "http://schema.org/",
+		"s":      "http://schema.org/",
+		"ex":     "http://example.com/",
+		"exp":    "http://example.com/people/",
+	}
+
+	t.Run("ok - compacts IRIs and declares used prefixes", func(t *testing.T) {
+		q := New(Eq(NewTermPath("http://schema.org/name"), ScalarMustParse("Jane \"J\" Doe\n"))).
+			And(Range(NewTermPath("http://example.com/people/weight"), ScalarMustParse(70.0), ScalarMustParse(1e21))).
+			And(Prefix(NewTermPath("http://other.org/c", "http://schema.org/"), ScalarMustParse(false)))
+
+		text, err := FormatQuery(q, prefixes)
+
+		assert.NoError(t, err)
+		assert.Equal(t, `PREFIX exp: <http://example.com/people/>
+PREFIX s: <http://schema.org/>
+s:name = "Jane \"J\" Doe\n" AND exp:weight BETWEEN 70 AND 1e+21 AND <http://other.org/c>/s: STARTS WITH false`, text)
+	})
+
+	t.Run("ok - round trip", func(t *testing.T) {
+		queries := []Query{
+			New(IsType("http://schema.org/Person")).And(InGraph("http://example.com/graph")),
+			New(Eq(NewTermPath(AllGraphsTerm, WildcardTerm, DescendantTerm, IDTerm), ScalarMustParse("tab\there\\"))),
+			New(Eq(NewTermPath(TypeFilterTerm("http://schema.org/Person"), IDFilterTerm("http://example.com/1"),
+				ReverseTerm("http://schema.org/parent"), DereferenceTerm, "http://schema.org/name"), ScalarMustParse(-1.25))),
+			New(Range(NewTermPath("http://schema.org/name."), ScalarMustParse("a"), ScalarMustParse("b"))),
+			New(GreaterThan(NewTermPath("http://schema.org/weight"), ScalarMustParse(80.0))).
+				And(GreaterThanOrEqual(NewTermPath("http://schema.org/weight"), ScalarMustParse(70.0))).
+				And(LessThan(NewTermPath("http://schema.org/name"), ScalarMustParse("b"))).
+				And(LessThanOrEqual(NewTermPath("http://schema.org/name"), ScalarMustParse("a"))),
+		}
+		for _, q := range queries {
+			text, err := FormatQuery(q, prefixes)
+			if !assert.NoError(t, err) {
+				continue
+			}
+
+			parsed, err := ParseQuery(text)
+
+			if assert.NoError(t, err, text) {
+				assert.Equal(t, q.Parts(), parsed.Parts(), text)
+			}
+		}
+	})
+
+	t.Run("ok - without prefixes", func(t *testing.T) {
+		text, err := FormatQuery(New(IsType("http://schema.org/Person")), nil)
+
+		assert.NoError(t, err)
+		assert.Equal(t, `@type = "http://schema.org/Person"`, text)
+	})
+
+	t.Run("error - IRI that can't be written", func(t *testing.T) {
+		_, err := FormatQuery(New(Eq(NewTermPath("http://other.org/a b"), ScalarMustParse(1.0))), prefixes)
+
+		assert.True(t, errors.Is(err, ErrUnsupportedQueryPart))
+	})
+
+	t.Run("error - custom query part", func(t *testing.T) {
+		_, err := FormatQuery(New(testQueryPart{}), prefixes)
+
+		assert.True(t, errors.Is(err, ErrUnsupportedQueryPart))
+	})
+
+	t.Run("error - infinite number", func(t *testing.T) {
+		_, err := FormatQuery(New(Eq(NewTermPath("http://schema.org/weight"), ScalarMustParse(math.Inf(1)))), prefixes)
+
+		assert.True(t, errors.Is(err, ErrUnsupportedQueryPart))
+	})
+}
+
+type testQueryPart struct {
+	eqPart
+}
@@ -0,0 +1,224 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/piprate/json-gold/ld"
+)
+
+// readNQuads parses the N-Quads line by line, lines that can't be parsed are added to the report.
+// The dataset is converted to JSON-LD when all lines have been read since the triples of a node may be spread over the input.
+// Every node with an IRI becomes a document, blank nodes that are referenced once are embedded in the referencing node.
+func (c *collection) readNQuads(reader io.Reader, emit func(importLine) error, report *ImportReport) error {
+	valid := strings.Builder{}
+	// firstLine maps a subject or graph name to the line where it's first used
+	firstLine := map[string]int{}
+
+	scanner := bufio.NewReader(reader)
+	line := 0
+	for {
+		raw, readErr := scanner.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		line++
+
+		if quad := strings.TrimSpace(raw); len(quad) > 0 && !strings.HasPrefix(quad, "#") {
+			dataset, err := ld.ParseNQuads(quad)
+			if err != nil {
+				report.Errors = append(report.Errors, LineError{Line: line, Err: ErrInvalidNQuad})
+			} else {
+				valid.WriteString(quad)
+				valid.WriteString("\n")
+				for name, quads := range dataset.Graphs {
+					if len(quads) == 0 {
+						continue
+					}
+					key := quads[0].Subject.GetValue()
+					if name != "@default" {
+						key = name
+					}
+					if _, ok := firstLine[key]; !ok {
+						firstLine[key] = line
+					}
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	options := c.jsonLdOptions()
+	options.UseNativeTypes = true
+	nodes, err := c.documentProcessor.FromRDF(valid.String(), options)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range embedBlankNodes(nodes.([]interface{})) {
+		doc, err := json.Marshal(node)
+		if err != nil {
+			return err
+		}
+		id, _ := node["@id"].(string)
+		if err = emit(importLine{line: firstLine[id], doc: doc}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// embedBlankNodes replaces references to blank nodes that are referenced exactly once with the node itself.
+// It returns the nodes that haven't been embedded, named graphs are processed recursively.
+func embedBlankNodes(nodes []interface{}) []map[string]interface{} {
+	references := map[string]int{}
+	blankNodes := map[string]map[string]interface{}{}
+	for _, n := range nodes {
+		node := n.(map[string]interface{})
+		id, _ := node["@id"].(string)
+		if strings.HasPrefix(id, "_:") {
+			blankNodes[id] = node
+		}
+		forEachReference(node, func(ref string) {
+			references[ref]++
+		})
+	}
+
+	placed := map[string]bool{}
+	var embed func(node map[string]interface{})
+	embed = func(node map[string]interface{}) {
+		for key, value := range node {
+			if key == "@graph" {
+				node[key] = toInterfaceSlice(embedBlankNodes(value.([]interface{})))
+				continue
+			}
+			values, ok := value.([]interface{})
+			if !ok || strings.HasPrefix(key, "@") {
+				continue
+			}
+			for j, v := range values {
+				ref, ok := v.(map[string]interface{})
+				if !ok || len(ref) != 1 {
+					continue
+				}
+				id, _ := ref["@id"].(string)
+				target, isBlank := blankNodes[id]
+				if !isBlank || references[id] != 1 || placed[id] {
+					continue
+				}
+				placed[id] = true
+				delete(target, "@id")
+				embed(target)
+				values[j] = target
+			}
+		}
+	}
+
+	// nodes with an IRI are the roots, blank nodes that aren't embedded are processed afterwards
+	result := make([]map[string]interface{}, 0, len(nodes))
+	for _, roots := range []bool{true, false} {
+		for _, n := range nodes {
+			node := n.(map[string]interface{})
+			id, _ := node["@id"].(string)
+			if strings.HasPrefix(id, "_:") == roots || placed[id] {
+				continue
+			}
+			placed[id] = true
+			embed(node)
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// forEachReference calls fn with the @id of every node reference in the properties of the node
+func forEachReference(node map[string]interface{}, fn func(ref string)) {
+	for key, value := range node {
+		values, ok := value.([]interface{})
+		if !ok || strings.HasPrefix(key, "@") {
+			continue
+		}
+		for _, v := range values {
+			if ref, ok := v.(map[string]interface{}); ok && len(ref) == 1 {
+				if id, ok := ref["@id"].(string); ok {
+					fn(id)
+				}
+			}
+		}
+	}
+}
+
+func toInterfaceSlice(nodes []map[string]interface{}) []interface{} {
+	result := make([]interface{}, len(nodes))
+	for j, node := range nodes {
+		result[j] = node
+	}
+	return result
+}
+
+// writeNQuads writes the document as sorted N-Quads.
+// Blank node labels are prefixed with the document number so they don't clash between documents.
+func (c *collection) writeNQuads(writer io.Writer, doc Document, number int) error {
+	var input interface{}
+	if err := json.Unmarshal(doc, &input); err != nil {
+		return err
+	}
+
+	rdf, err := c.documentProcessor.ToRDF(input, c.jsonLdOptions())
+	if err != nil {
+		return err
+	}
+	dataset := rdf.(*ld.RDFDataset)
+
+	relabel := func(node ld.Node) ld.Node {
+		if blank, ok := node.(*ld.BlankNode); ok {
+			return ld.NewBlankNode(fmt.Sprintf("_:d%d%s", number, strings.TrimPrefix(blank.Attribute, "_:")))
+		}
+		return node
+	}
+	relabeled := ld.NewRDFDataset()
+	for name, quads := range dataset.Graphs {
+		if strings.HasPrefix(name, "_:") {
+			name = relabel(ld.NewBlankNode(name)).GetValue()
+		}
+		for _, quad := range quads {
+			relabeled.Graphs[name] = append(relabeled.Graphs[name], ld.NewQuad(relabel(quad.Subject), quad.Predicate, relabel(quad.Object), name))
+		}
+	}
+
+	serializer := ld.NQuadRDFSerializer{}
+	serialized, err := serializer.Serialize(relabeled)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(serialized.(string), "\n")
+	sort.Strings(lines)
+	_, err = io.WriteString(writer, strings.Join(lines, ""))
+	return err
+}
@@ -0,0 +1,129 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const nQuadsExample = `# people
+<http://example.com/jane> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://schema.org/Person> .
+<http://example.com/jane> <http://schema.org/name> "Jane Doe" .
+<http://example.com/jane> <http://schema.org/address> _:b0 .
+this is not a quad
+_:b0 <http://schema.org/addressLocality> "Amsterdam" .
+<http://example.com/john> <http://schema.org/name> "John Doe" <http://example.com/graph> .
+<http://example.com/jane> <http://schema.org/weight> "80"^^<http://www.w3.org/2001/XMLSchema#integer> .
+`
+
+func TestCollection_ImportNQuads(t *testing.T) {
+	nameTermPath := NewTermPath("http://schema.org/name")
+	localityTermPath := NewTermPath("http://schema.org/address", "http://schema.org/addressLocality")
+
+	t.Run("ok", func(t *testing.T) {
+		c := createCollection(testDB(t))
+
+		report, err := c.Import(context.Background(), strings.NewReader(nQuadsExample), FormatNQuads)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 2, report.Imported)
+		if assert.Len(t, report.Errors, 1) {
+			assert.Equal(t, 5, report.Errors[0].Line)
+			assert.True(t, errors.Is(report.Errors[0], ErrInvalidNQuad))
+		}
+
+		docs, err := c.Find(context.Background(), New(Eq(nameTermPath, ScalarMustParse("Jane Doe"))))
+		if !assert.NoError(t, err) || !assert.Len(t, docs, 1) {
+			return
+		}
+		values, _ := c.ValuesAtPath(docs[0], localityTermPath)
+		assert.Equal(t, []Scalar{ScalarMustParse("Amsterdam")}, values)
+		values, _ = c.ValuesAtPath(docs[0], NewTermPath("http://schema.org/weight"))
+		assert.Equal(t, []Scalar{ScalarMustParse(80.0)}, values)
+	})
+
+	t.Run("ok - named graph", func(t *testing.T) {
+		c := createCollection(testDB(t))
+
+		_, _ = c.Import(context.Background(), strings.NewReader(nQuadsExample), FormatNQuads)
+
+		docs, _ := c.Find(context.Background(), New(Eq(NewTermPath("@graph", "http://schema.org/name"), ScalarMustParse("John Doe"))))
+		assert.Len(t, docs, 1)
+	})
+
+	t.Run("ok - blank node referenced twice is not embedded", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		input := `<http://example.com/jane> <http://schema.org/address> _:b0 .
+<http://example.com/john> <http://schema.org/address> _:b0 .
+_:b0 <http://schema.org/addressLocality> "Amsterdam" .`
+
+		report, err := c.Import(context.Background(), strings.NewReader(input), FormatNQuads)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, report.Imported)
+	})
+}
+
+func TestCollection_ExportNQuads(t *testing.T) {
+	nameQuery := New(Eq(NewTermPath("http://schema.org/name"), ScalarMustParse("Jane Doe")))
+
+	t.Run("ok", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		buffer := bytes.Buffer{}
+
+		err := c.Export(context.Background(), &buffer, nameQuery, FormatNQuads)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Contains(t, buffer.String(), `<http://schema.org/name> "Jane Doe" .`)
+		assert.NotContains(t, buffer.String(), "Soldier")
+	})
+
+	t.Run("ok - round trip", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		buffer := bytes.Buffer{}
+		_ = c.Export(context.Background(), &buffer, nil, FormatNQuads)
+		target := createCollection(testDB(t))
+
+		report, err := target.Import(context.Background(), &buffer, FormatNQuads)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		// the blank node labels are unique per document, so the children are embedded again
+		assert.Equal(t, 2, report.Imported)
+		docs, _ := target.Find(context.Background(), nameQuery)
+		if assert.Len(t, docs, 1) {
+			values, _ := target.ValuesAtPath(docs[0], NewTermPath("http://schema.org/children", "http://schema.org/name"))
+			assert.Equal(t, []Scalar{ScalarMustParse("John Doe")}, values)
+		}
+	})
+}
@@ -0,0 +1,324 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/piprate/json-gold/ld"
+	"go.etcd.io/bbolt"
+)
+
+// ErrReferenceFuncMismatch is returned by writes when the collection has been written with another reference function
+var ErrReferenceFuncMismatch = errors.New("reference function doesn't match the reference function of the stored documents")
+
+// ErrCanonicalReference is returned by writes when the canonical reference of a document can't be created
+var ErrCanonicalReference = errors.New("unable to create the canonical reference")
+
+// ErrReferenceNotDerivable is returned by Delete when the reference function doesn't derive the reference from the document
+var ErrReferenceNotDerivable = errors.New("reference can't be derived from the document, use DeleteByReference or DeleteWhere")
+
+// ErrReferenceConflict is returned by Rekey when different documents get the same reference
+var ErrReferenceConflict = errors.New("documents have the same reference")
+
+// referenceFuncBucket is the bucket within the metaBucket that stores the name of the reference function per collection
+const referenceFuncBucket = "referenceFuncs"
+
+// Names of the built-in reference functions, they're stored in the collection metadata.
+const (
+	// SHA1ReferenceFuncName is the name of the default reference function, the SHA-1 hash of the document bytes
+	SHA1ReferenceFuncName = "sha1"
+	// SHA256ReferenceFuncName is the name of SHA256ReferenceFunc
+	SHA256ReferenceFuncName = "sha256"
+	// CanonicalReferenceFuncName is the name of the reference function configured by WithCanonicalReferences
+	CanonicalReferenceFuncName = "urdna2015-sha256"
+	// TimeOrderedReferenceFuncName is the name of TimeOrderedReferenceFunc
+	TimeOrderedReferenceFuncName = "ulid"
+	// IDReferenceFuncName is the name of IDReferenceFunc
+	IDReferenceFuncName = "id"
+)
+
+// WithReferenceFunc sets the function that creates the reference under which a document is stored.
+// The name identifies the function, it's stored with the collection on the first write.
+// Writes return ErrReferenceFuncMismatch when the collection has been written with a function with another name,
+// so a reopened store can't silently mix references. Reads are not affected.
+// A collection with documents that has been written before the name was stored is assumed to use SHA-1 references.
+// Use Collection.Rekey to change the reference function of a collection with documents.
+func WithReferenceFunc(name string, fn ReferenceFunc) CollectionOption {
+	return func(collection *collection) {
+		collection.refName = name
+		collection.refMake = fn
+		collection.refCreate = nil
+	}
+}
+
+// WithSHA256References configures the collection to use SHA256ReferenceFunc
+func WithSHA256References() CollectionOption {
+	return WithReferenceFunc(SHA256ReferenceFuncName, SHA256ReferenceFunc)
+}
+
+// WithTimeOrderedReferences configures the collection to use TimeOrderedReferenceFunc.
+// Documents are iterated in insertion order when no index is used, also within a single call to Add or Import.
+// Delete and BatchDelete return ErrReferenceNotDerivable, use DeleteByReference or DeleteWhere instead.
+func WithTimeOrderedReferences() CollectionOption {
+	return WithReferenceFunc(TimeOrderedReferenceFuncName, TimeOrderedReferenceFunc)
+}
+
+// WithIDReferences configures the collection to use IDReferenceFunc
+func WithIDReferences() CollectionOption {
+	return WithReferenceFunc(IDReferenceFuncName, IDReferenceFunc)
+}
+
+// WithCanonicalReferences configures the collection to use references that don't depend on the serialization of a document.
+// The document is normalized with the URDNA2015 algorithm and the canonical N-Quads are hashed with SHA-256.
+// The same document with different whitespace, key order or context will therefore only be stored once.
+// The document loader of the store is used to resolve contexts.
+// Writes return ErrCanonicalReference for documents that can't be normalized, for instance because a context can't be loaded,
+// or that don't contain any statements. Collection.Reference returns nil for these documents.
+//
+// Documents stored before the reference function was recorded remain available through Get, Find and Iterate.
+// Use DeleteByReference to delete them, since Delete derives the reference from the document.
+// Collections that recorded another reference function return ErrReferenceFuncMismatch on writes, see WithReferenceFunc.
+// Collection.Rekey stores the existing documents under their canonical reference, after which writes succeed.
+func WithCanonicalReferences() CollectionOption {
+	return func(collection *collection) {
+		create := canonicalReference(collection.documentProcessor, collection.jsonLdOptions())
+		collection.refName = CanonicalReferenceFuncName
+		collection.refCreate = create
+		collection.refMake = func(doc Document) Reference {
+			ref, _ := create(doc)
+			return ref
+		}
+	}
+}
+
+// canonicalReference returns a function that hashes the URDNA2015 normalized form of a document.
+// The raw bytes are never hashed, so a document gets the same reference or none at all.
+func canonicalReference(processor *ld.JsonLdProcessor, options *ld.JsonLdOptions) func(doc Document) (Reference, error) {
+	options = options.Copy()
+	options.Algorithm = "URDNA2015"
+	options.Format = "application/n-quads"
+
+	return func(doc Document) (Reference, error) {
+		var input interface{}
+		if err := json.Unmarshal(doc, &input); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCanonicalReference, err)
+		}
+		normalized, err := processor.Normalize(input, options)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCanonicalReference, err)
+		}
+		nQuads, ok := normalized.(string)
+		if !ok || len(nQuads) == 0 {
+			return nil, fmt.Errorf("%w: the document doesn't contain any statements", ErrCanonicalReference)
+		}
+		return sha256Reference([]byte(nQuads)), nil
+	}
+}
+
+// SHA256ReferenceFunc creates references from the SHA-256 hash of the document bytes
+func SHA256ReferenceFunc(doc Document) Reference {
+	return sha256Reference(doc)
+}
+
+// timeOrderedState makes the references created within the same millisecond increase monotonically
+var timeOrderedState = struct {
+	sync.Mutex
+	last Reference
+}{}
+
+// TimeOrderedReferenceFunc creates ULID references: a 48-bit millisecond timestamp followed by 80 random bits.
+// References created within the same millisecond are incremented, so references are ordered by creation.
+// The reference doesn't depend on the document: adding the same document twice stores it twice
+// and Delete returns ErrReferenceNotDerivable. Use DeleteByReference or DeleteWhere instead.
+func TimeOrderedReferenceFunc(_ Document) Reference {
+	ref := make(Reference, 16)
+	binary.BigEndian.PutUint64(ref, uint64(time.Now().UnixNano()/int64(time.Millisecond))<<16)
+
+	timeOrderedState.Lock()
+	defer timeOrderedState.Unlock()
+
+	last := timeOrderedState.last
+	if last != nil && string(last[:6]) >= string(ref[:6]) {
+		// same millisecond (or a clock that moved back): increment the previous reference
+		copy(ref, last)
+		for j := len(ref) - 1; j >= 6; j-- {
+			ref[j]++
+			if ref[j] != 0 {
+				break
+			}
+		}
+	} else if _, err := rand.Read(ref[6:]); err != nil {
+		panic(fmt.Sprintf("unable to create time ordered reference: %v", err))
+	}
+
+	timeOrderedState.last = append(Reference{}, ref...)
+	return ref
+}
+
+// IDReferenceFunc uses the value of the top-level @id as reference, so a document can be retrieved by its @id.
+// The value is used as is: compact IRIs and relative IRIs are not expanded.
+// Documents without a top-level @id use the SHA-256 hash of the document bytes.
+// Adding a document with the @id of a stored document replaces the stored document.
+func IDReferenceFunc(doc Document) Reference {
+	node := struct {
+		ID *string `json:"@id"`
+	}{}
+	if err := json.Unmarshal(doc, &node); err == nil && node.ID != nil && *node.ID != "" {
+		return Reference(*node.ID)
+	}
+	return sha256Reference(doc)
+}
+
+// reference creates the reference of a document with the configured reference function
+func (c *collection) reference(doc Document) (Reference, error) {
+	if c.refCreate != nil {
+		return c.refCreate(doc)
+	}
+	return c.refMake(doc), nil
+}
+
+// checkDerivable returns ErrReferenceNotDerivable if the reference function doesn't derive the reference from the document
+func (c *collection) checkDerivable() error {
+	if c.referenceFuncName() == TimeOrderedReferenceFuncName {
+		return ErrReferenceNotDerivable
+	}
+	return nil
+}
+
+// referenceFuncName returns the name of the configured reference function
+func (c *collection) referenceFuncName() string {
+	if c.refName == "" {
+		return SHA1ReferenceFuncName
+	}
+	return c.refName
+}
+
+// referenceFuncNames returns the bucket that stores the name of the reference function per collection
+func referenceFuncNames(tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return nil, err
+	}
+	return meta.CreateBucketIfNotExists([]byte(referenceFuncBucket))
+}
+
+// checkReferenceFunc stores the name of the reference function on the first write and compares it on later writes
+func (c *collection) checkReferenceFunc(tx *bbolt.Tx) error {
+	name := c.referenceFuncName()
+	names, err := referenceFuncNames(tx)
+	if err != nil {
+		return err
+	}
+
+	stored := names.Get([]byte(c.Name))
+	if stored == nil {
+		stored = []byte(name)
+		if docBucket := c.documentBucket(tx); docBucket != nil {
+			if ref, _ := docBucket.Cursor().First(); ref != nil {
+				// the collection has been written before the name was stored, only SHA-1 references existed then
+				stored = []byte(SHA1ReferenceFuncName)
+			}
+		}
+		if string(stored) == name {
+			return names.Put([]byte(c.Name), stored)
+		}
+	}
+	if string(stored) != name {
+		return fmt.Errorf("%w: collection %s uses %s, configured %s, use Rekey to change it", ErrReferenceFuncMismatch, c.Name, stored, name)
+	}
+	return nil
+}
+
+func (c *collection) Rekey(ctx context.Context) error {
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		return c.rekey(ctx, tx)
+	})
+}
+
+// rekey removes all documents with their current reference and adds them again with the configured reference function.
+// The index entries, expanded forms and nodes are moved along with the documents.
+func (c *collection) rekey(ctx context.Context, tx *bbolt.Tx) error {
+	expand := c.expander(tx)
+	// like prepareDocument, documents are only expanded when the expanded form is used
+	needsExpanded := len(c.writeIndices()) > 0 || c.storeExpanded || c.nodeIndex
+	current := make([]preparedDocument, 0)
+	rekeyed := make([]preparedDocument, 0)
+	refs := map[string]bool{}
+	if docBucket := c.documentBucket(tx); docBucket != nil {
+		cursor := docBucket.Cursor()
+		for ref, doc := cursor.First(); ref != nil; ref, doc = cursor.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// the values are only valid until the documents are deleted
+			ref, doc = append(Reference{}, ref...), append(Document{}, doc...)
+			var expanded []interface{}
+			if needsExpanded {
+				var err error
+				if expanded, err = expand(ref, doc); err != nil {
+					return err
+				}
+			}
+			newRef, err := c.reference(doc)
+			if err != nil {
+				return err
+			}
+			if refs[string(newRef)] {
+				return fmt.Errorf("%w: %s", ErrReferenceConflict, newRef.EncodeToString())
+			}
+			refs[string(newRef)] = true
+			current = append(current, preparedDocument{ref: ref, doc: doc, expanded: expanded})
+			rekeyed = append(rekeyed, preparedDocument{ref: newRef, doc: doc, expanded: expanded})
+		}
+	}
+
+	for _, p := range current {
+		if err := c.deletePrepared(tx, p); err != nil {
+			return err
+		}
+	}
+
+	names, err := referenceFuncNames(tx)
+	if err != nil {
+		return err
+	}
+	if err = names.Put([]byte(c.Name), []byte(c.referenceFuncName())); err != nil {
+		return err
+	}
+
+	if len(rekeyed) == 0 {
+		return nil
+	}
+	return c.addPrepared(tx, rekeyed)
+}
+
+func sha256Reference(data []byte) Reference {
+	s := sha256.Sum256(data)
+	return s[:]
+}
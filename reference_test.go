@@ -0,0 +1,430 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piprate/json-gold/ld"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestWithCanonicalReferences(t *testing.T) {
+	personQuery := New(IsType("http://schema.org/Person"))
+
+	t.Run("ok - same reference for equivalent documents", func(t *testing.T) {
+		c := testStore(t).Collection("test", WithCanonicalReferences())
+		doc1 := []byte(`{"@context": ["http://schema.org/"], "@type": "Person", "name": "Jane Doe"}`)
+		doc2 := []byte(`{
+  "name": "Jane Doe",
+  "@type": "http://schema.org/Person",
+  "@context": {"name": "http://schema.org/name"}
+}`)
+
+		assert.Equal(t, c.Reference(doc1), c.Reference(doc2))
+		assert.Len(t, c.Reference(doc1), 32)
+
+		_ = c.Add([]Document{doc1, doc2})
+		docs, _ := c.Find(context.Background(), personQuery)
+		assert.Len(t, docs, 1)
+	})
+
+	t.Run("ok - different documents", func(t *testing.T) {
+		c := testStore(t).Collection("test", WithCanonicalReferences())
+
+		assert.NotEqual(t, c.Reference(jsonLdExample), c.Reference(jsonLdExample2))
+	})
+
+	t.Run("error - documents without statements", func(t *testing.T) {
+		c := testStore(t).Collection("test", WithCanonicalReferences())
+
+		err := c.Add([]Document{[]byte(`{"a": 1}`)})
+
+		assert.True(t, errors.Is(err, ErrCanonicalReference))
+		assert.Nil(t, c.Reference([]byte(`{"a": 1}`)))
+	})
+
+	t.Run("error - invalid JSON", func(t *testing.T) {
+		c := testStore(t).Collection("test", WithCanonicalReferences())
+
+		err := c.Add([]Document{[]byte(`{`)})
+
+		assert.True(t, errors.Is(err, ErrCanonicalReference))
+	})
+
+	t.Run("error - context can't be loaded", func(t *testing.T) {
+		dbFile := filepath.Join(testDirectory(t), "test.db")
+		s, _ := NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		c := s.Collection("test", WithCanonicalReferences())
+		_ = c.Add([]Document{jsonLdExample})
+		ref := c.Reference(jsonLdExample)
+		_ = s.Close()
+
+		s, _ = NewStore(dbFile, WithoutSync(), WithDocumentLoader(failingDocumentLoader{}))
+		defer s.Close()
+		c = s.Collection("test", WithCanonicalReferences())
+
+		err := c.Add([]Document{jsonLdExample})
+		assert.True(t, errors.Is(err, ErrCanonicalReference))
+		err = c.Delete(jsonLdExample)
+		assert.True(t, errors.Is(err, ErrCanonicalReference))
+		doc, _ := c.Get(ref)
+		assert.Equal(t, Document(jsonLdExample), doc)
+	})
+
+	t.Run("ok - existing documents remain readable", func(t *testing.T) {
+		dbFile := filepath.Join(testDirectory(t), "test.db")
+		s, _ := NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		c := s.Collection("test")
+		_ = c.Add([]Document{jsonLdExample})
+		ref := c.Reference(jsonLdExample)
+		_ = s.Close()
+
+		s, _ = NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		defer s.Close()
+		c = s.Collection("test", WithCanonicalReferences())
+
+		doc, err := c.Get(ref)
+		assert.NoError(t, err)
+		assert.Equal(t, Document(jsonLdExample), doc)
+		docs, _ := c.Find(context.Background(), personQuery)
+		assert.Len(t, docs, 1)
+		assert.NoError(t, c.DeleteByReference(ref))
+		docs, _ = c.Find(context.Background(), personQuery)
+		assert.Len(t, docs, 0)
+	})
+}
+
+// failingDocumentLoader fails to load any document, like a loader without network access
+type failingDocumentLoader struct{}
+
+func (failingDocumentLoader) LoadDocument(u string) (*ld.RemoteDocument, error) {
+	return nil, ld.NewJsonLdError(ld.LoadingDocumentFailed, u)
+}
+
+func TestWithReferenceFunc(t *testing.T) {
+	t.Run("ok - custom function", func(t *testing.T) {
+		c := testStore(t).Collection("test", WithReferenceFunc("constant", func(doc Document) Reference {
+			return Reference("constant")
+		}))
+
+		_ = c.Add([]Document{jsonLdExample})
+
+		doc, _ := c.Get(Reference("constant"))
+		assert.Equal(t, Document(jsonLdExample), doc)
+	})
+
+	t.Run("ok - same function after reopening", func(t *testing.T) {
+		dbFile := filepath.Join(testDirectory(t), "test.db")
+		s, _ := NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		_ = s.Collection("test", WithSHA256References()).Add([]Document{jsonLdExample})
+		_ = s.Close()
+
+		s, _ = NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		defer s.Close()
+		c := s.Collection("test", WithSHA256References())
+
+		assert.NoError(t, c.Add([]Document{jsonLdExample2}))
+		assert.NoError(t, c.Delete(jsonLdExample))
+		doc, _ := c.Get(SHA256ReferenceFunc(jsonLdExample2))
+		assert.NotNil(t, doc)
+	})
+
+	t.Run("error - other function after reopening", func(t *testing.T) {
+		dbFile := filepath.Join(testDirectory(t), "test.db")
+		s, _ := NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		_ = s.Collection("test").Add([]Document{jsonLdExample})
+		_ = s.Close()
+
+		s, _ = NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		defer s.Close()
+		c := s.Collection("test", WithSHA256References())
+
+		assert.True(t, errors.Is(c.Add([]Document{jsonLdExample2}), ErrReferenceFuncMismatch))
+		assert.True(t, errors.Is(c.Delete(jsonLdExample), ErrReferenceFuncMismatch))
+		assert.True(t, errors.Is(c.BatchDelete([]Document{jsonLdExample}), ErrReferenceFuncMismatch))
+		// reads and deletes by reference still work
+		ref := defaultReferenceCreator(jsonLdExample)
+		doc, _ := c.Get(ref)
+		assert.NotNil(t, doc)
+		assert.NoError(t, c.DeleteByReference(ref))
+	})
+
+	// writeUnnamed adds the document to a collection like a version that didn't store the name of the reference function
+	writeUnnamed := func(t *testing.T, dbFile string, doc Document) {
+		s, _ := NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		_ = s.Collection("test").Add([]Document{doc})
+		_ = s.(*store).db.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket([]byte(metaBucket)).Bucket([]byte(referenceFuncBucket)).Delete([]byte("test"))
+		})
+		_ = s.Close()
+	}
+
+	t.Run("ok - SHA-1 for a collection written before the name was stored", func(t *testing.T) {
+		dbFile := filepath.Join(testDirectory(t), "test.db")
+		writeUnnamed(t, dbFile, jsonLdExample)
+
+		s, _ := NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		defer s.Close()
+		c := s.Collection("test")
+
+		assert.NoError(t, c.Add([]Document{jsonLdExample2}))
+		// an empty collection can use another function
+		assert.NoError(t, s.Collection("other", WithIDReferences()).Add([]Document{jsonLdExample}))
+	})
+
+	t.Run("error - other function for a collection written before the name was stored", func(t *testing.T) {
+		dbFile := filepath.Join(testDirectory(t), "test.db")
+		writeUnnamed(t, dbFile, jsonLdExample)
+
+		s, _ := NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		defer s.Close()
+		c := s.Collection("test", WithIDReferences())
+
+		err := c.Add([]Document{jsonLdExample2})
+
+		assert.True(t, errors.Is(err, ErrReferenceFuncMismatch))
+		assert.NoError(t, c.Rekey(context.Background()))
+		assert.NoError(t, c.Add([]Document{jsonLdExample2}))
+	})
+}
+
+func TestCollection_Rekey(t *testing.T) {
+	personQuery := New(IsType("http://schema.org/Person"))
+
+	t.Run("ok - canonical references for existing documents", func(t *testing.T) {
+		dbFile := filepath.Join(testDirectory(t), "test.db")
+		s, _ := NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		c := s.Collection("test")
+		_ = c.AddIndex(c.NewIndex("type", NewFieldIndexer(NewTermPath(TypeTerm))))
+		_ = c.Add([]Document{jsonLdExample})
+		oldRef := c.Reference(jsonLdExample)
+		_ = s.Close()
+
+		s, _ = NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		defer s.Close()
+		c = s.Collection("test", WithCanonicalReferences(), WithExpandedStorage(), WithNodeIndex())
+		_ = c.AddIndex(c.NewIndex("type", NewFieldIndexer(NewTermPath(TypeTerm))))
+
+		err := c.Rekey(context.Background())
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		doc, _ := c.Get(oldRef)
+		assert.Nil(t, doc)
+		doc, _ = c.Get(c.Reference(jsonLdExample))
+		assert.Equal(t, Document(jsonLdExample), doc)
+		docs, _ := c.Find(context.Background(), personQuery)
+		assert.Len(t, docs, 1)
+		report, _ := c.Verify(context.Background())
+		assert.True(t, report.IsConsistent())
+		assert.NoError(t, c.Add([]Document{jsonLdExample2}))
+		assert.NoError(t, c.Delete(jsonLdExample))
+	})
+
+	t.Run("ok - empty collection", func(t *testing.T) {
+		c := testStore(t).Collection("test", WithSHA256References())
+
+		assert.NoError(t, c.Rekey(context.Background()))
+		assert.NoError(t, c.Add([]Document{jsonLdExample}))
+	})
+
+	t.Run("error - same reference for different documents", func(t *testing.T) {
+		dbFile := filepath.Join(testDirectory(t), "test.db")
+		s, _ := NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		_ = s.Collection("test").Add([]Document{jsonLdExample, jsonLdExample2})
+		_ = s.Close()
+
+		s, _ = NewStore(dbFile, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		defer s.Close()
+		c := s.Collection("test", WithReferenceFunc("constant", func(doc Document) Reference {
+			return Reference("constant")
+		}))
+
+		err := c.Rekey(context.Background())
+
+		assert.True(t, errors.Is(err, ErrReferenceConflict))
+		// nothing has changed
+		doc, _ := c.Get(defaultReferenceCreator(jsonLdExample))
+		assert.NotNil(t, doc)
+		assert.True(t, errors.Is(c.Add([]Document{jsonLdExample}), ErrReferenceFuncMismatch))
+	})
+
+	t.Run("error - cancelled", func(t *testing.T) {
+		c := testStore(t).Collection("test")
+		_ = c.Add([]Document{jsonLdExample})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := c.Rekey(ctx)
+
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
+func TestSHA256ReferenceFunc(t *testing.T) {
+	ref := SHA256ReferenceFunc([]byte("test"))
+
+	assert.Equal(t, "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", hex.EncodeToString(ref))
+}
+
+func TestTimeOrderedReferenceFunc(t *testing.T) {
+	t.Run("ok - ordered", func(t *testing.T) {
+		refs := make([]Reference, 1000)
+		for j := range refs {
+			refs[j] = TimeOrderedReferenceFunc(jsonLdExample)
+		}
+
+		for j := 1; j < len(refs); j++ {
+			assert.Len(t, refs[j], 16)
+			assert.Equal(t, -1, bytes.Compare(refs[j-1], refs[j]))
+		}
+	})
+
+	t.Run("ok - iterated in insertion order", func(t *testing.T) {
+		c := testStore(t).Collection("test", WithTimeOrderedReferences())
+		docs := []Document{jsonLdExample2, jsonLdExample, jsonLdExample2}
+		for _, doc := range docs {
+			_ = c.Add([]Document{doc})
+		}
+
+		var found []Document
+		_ = c.Iterate(New(IsType("http://schema.org/Person")), func(key Reference, value []byte) error {
+			found = append(found, value)
+			return nil
+		})
+
+		assert.Equal(t, docs, found)
+	})
+
+	t.Run("ok - iterated in insertion order within a single call", func(t *testing.T) {
+		docs := make([]Document, 10)
+		for j := range docs {
+			docs[j] = []byte(fmt.Sprintf(`{"@context": ["http://schema.org/"], "@type": "Person", "identifier": "%d"}`, j))
+		}
+		// a slow reference for the first document doesn't change the order
+		slowFirst := func(doc Document) Reference {
+			if bytes.Equal(doc, docs[0]) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			return TimeOrderedReferenceFunc(doc)
+		}
+		c := testStore(t).Collection("test", WithReferenceFunc(TimeOrderedReferenceFuncName, slowFirst), WithWorkers(4))
+		_ = c.Add(docs)
+
+		var found []Document
+		_ = c.Iterate(New(IsType("http://schema.org/Person")), func(key Reference, value []byte) error {
+			found = append(found, value)
+			return nil
+		})
+
+		assert.Equal(t, docs, found)
+	})
+
+	t.Run("error - delete by document", func(t *testing.T) {
+		c := testStore(t).Collection("test", WithTimeOrderedReferences())
+		_ = c.Add([]Document{jsonLdExample})
+
+		assert.Equal(t, ErrReferenceNotDerivable, c.Delete(jsonLdExample))
+		assert.Equal(t, ErrReferenceNotDerivable, c.BatchDelete([]Document{jsonLdExample}))
+		count, err := c.DeleteWhere(context.Background(), New(IsType("http://schema.org/Person")))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+}
+
+func TestIDReferenceFunc(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		ref := IDReferenceFunc([]byte(`{"@id": "http://example.com/jane", "name": "Jane Doe"}`))
+
+		assert.Equal(t, Reference("http://example.com/jane"), ref)
+	})
+
+	t.Run("ok - without @id", func(t *testing.T) {
+		ref := IDReferenceFunc(jsonLdExample)
+
+		assert.Equal(t, SHA256ReferenceFunc(jsonLdExample), ref)
+	})
+
+	t.Run("ok - get by @id", func(t *testing.T) {
+		c := testStore(t).Collection("test", WithIDReferences())
+		_ = c.Add([]Document{[]byte(`{"@id": "http://example.com/jane", "name": "Jane Doe"}`)})
+
+		doc, _ := c.Get(Reference("http://example.com/jane"))
+
+		assert.NotNil(t, doc)
+	})
+
+	nameTermPath := NewTermPath("http://schema.org/name")
+	janeDoe := Document(`{"@context": {"@vocab": "http://schema.org/"}, "@id": "http://example.com/jane", "name": "Jane Doe"}`)
+	janeSmith := Document(`{"@context": {"@vocab": "http://schema.org/"}, "@id": "http://example.com/jane", "name": "Jane Smith"}`)
+	assertReplaced := func(t *testing.T, c Collection) {
+		docs, err := c.Find(context.Background(), New(Eq(nameTermPath, ScalarMustParse("Jane Doe"))))
+		assert.NoError(t, err)
+		assert.Len(t, docs, 0)
+		docs, err = c.Find(context.Background(), New(Eq(nameTermPath, ScalarMustParse("Jane Smith"))))
+		assert.NoError(t, err)
+		assert.Equal(t, []Document{janeSmith}, docs)
+		report, err := c.Verify(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, report.IsConsistent())
+	}
+
+	t.Run("ok - adding the same @id replaces the stored document", func(t *testing.T) {
+		c := testStore(t).Collection("test", WithIDReferences(), WithExpandedStorage(), WithNodeIndex())
+		_ = c.AddIndex(c.NewIndex("name", NewFieldIndexer(nameTermPath)))
+		_ = c.Add([]Document{janeDoe})
+
+		err := c.Add([]Document{janeSmith})
+
+		assert.NoError(t, err)
+		assertReplaced(t, c)
+		// the node of the replaced document is removed
+		_ = c.(*collection).db.View(func(tx *bbolt.Tx) error {
+			nodes, err := c.(*collection).resolveNode(tx, "http://example.com/jane")
+			assert.NoError(t, err)
+			assert.Len(t, nodes, 1)
+			return nil
+		})
+	})
+
+	t.Run("ok - adding the same @id within a transaction replaces the stored document", func(t *testing.T) {
+		s := testStore(t)
+		c := s.Collection("test", WithIDReferences())
+		_ = c.AddIndex(c.NewIndex("name", NewFieldIndexer(nameTermPath)))
+		_ = c.Add([]Document{janeDoe})
+
+		err := s.Update(func(tx WriteTx) error {
+			return tx.Collection("test").Add([]Document{janeSmith})
+		})
+
+		assert.NoError(t, err)
+		assertReplaced(t, c)
+	})
+}
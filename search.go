@@ -21,7 +21,9 @@ package goauld
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
+	"math"
 )
 
 // ErrNoQuery is returned when an empty query is given
@@ -77,6 +79,41 @@ func Prefix(termPath TermPath, value Scalar) QueryPart {
 	}
 }
 
+// GreaterThan creates a query part that matches values after the given value.
+// Only values of the same type as the given value match: numbers are compared numerically, strings by their bytes.
+func GreaterThan(termPath TermPath, value Scalar) QueryPart {
+	return boundPart{termPath: termPath, value: value, lower: true}
+}
+
+// GreaterThanOrEqual is like GreaterThan, but also matches the given value
+func GreaterThanOrEqual(termPath TermPath, value Scalar) QueryPart {
+	return boundPart{termPath: termPath, value: value, lower: true, inclusive: true}
+}
+
+// LessThan creates a query part that matches values before the given value, see GreaterThan.
+// Documents without a value at the TermPath don't match.
+func LessThan(termPath TermPath, value Scalar) QueryPart {
+	return boundPart{termPath: termPath, value: value}
+}
+
+// LessThanOrEqual is like LessThan, but also matches the given value
+func LessThanOrEqual(termPath TermPath, value Scalar) QueryPart {
+	return boundPart{termPath: termPath, value: value, inclusive: true}
+}
+
+// IsType creates a query part that matches documents with the given IRI as @type of the root node.
+// An index on NewTermPath(TypeTerm) is used for this query part.
+func IsType(typeIRI string) QueryPart {
+	return Eq(NewTermPath(TypeTerm), ScalarMustParse(typeIRI))
+}
+
+// InGraph creates a query part that matches documents with a top-level named graph with the given IRI as @id.
+// An index on NewTermPath(GraphTerm(graphIRI)) is used for this query part.
+// To match values within the graph, start the TermPath of another query part with GraphTerm(graphIRI).
+func InGraph(graphIRI string) QueryPart {
+	return Eq(NewTermPath(GraphTerm(graphIRI)), ScalarMustParse(graphIRI))
+}
+
 type query struct {
 	parts []QueryPart
 }
@@ -152,6 +189,164 @@ func (r rangePart) Condition(key Key, transform Transform) bool {
 	return bytes.Compare(key, eTransformed.Bytes()) <= 0
 }
 
+// boundPart matches the values on one side of a value: after the value for a lower bound, before the value otherwise
+type boundPart struct {
+	termPath  TermPath
+	value     Scalar
+	lower     bool
+	inclusive bool
+}
+
+func (b boundPart) Equals(other IRIComparable) bool {
+	return b.termPath.Equals(other.TermPath())
+}
+
+func (b boundPart) TermPath() TermPath {
+	return b.termPath
+}
+
+// Seek returns the value when the matching keys start there, otherwise the first key.
+// The keys of numbers are ordered by their IEEE 754 bits: the positive numbers ascending, followed by the negative numbers descending.
+// A lower bound on a negative number therefore also matches the positive numbers at the start,
+// an upper bound on a positive number also matches the negative numbers at the end.
+func (b boundPart) Seek() Scalar {
+	if f, ok := b.value.value.(float64); ok {
+		if b.lower == math.Signbit(f) {
+			return Scalar{}
+		}
+		return b.value
+	}
+	if !b.lower {
+		return Scalar{}
+	}
+	return b.value
+}
+
+// Condition returns true if the key is a value of the same type that lies within the bound.
+// The keys of an index don't have a type: a number must have 8 bytes, a boolean a single byte.
+func (b boundPart) Condition(key Key, transform Transform) bool {
+	c, ok := b.compare(key, transform)
+	if !ok {
+		return false
+	}
+	if b.lower {
+		return c > 0 || (c == 0 && b.inclusive)
+	}
+	return c < 0 || (c == 0 && b.inclusive)
+}
+
+// operator returns the comparison operator of the textual query language
+func (b boundPart) operator() string {
+	operator := "<"
+	if b.lower {
+		operator = ">"
+	}
+	if b.inclusive {
+		operator += "="
+	}
+	return operator
+}
+
+// pastEnd returns true if the key comes after the keys that can match, see Seek for the order of numbers.
+// The keys of numbers end at -Inf, or at +Inf for a lower bound on a positive number.
+func (b boundPart) pastEnd(key Key, transform Transform) bool {
+	value := b.transformed(transform)
+	if f, ok := value.value.(float64); ok {
+		end := math.Inf(-1)
+		if b.lower && !math.Signbit(f) {
+			end = math.Inf(1)
+		} else if b.lower {
+			end = f
+		}
+		return bytes.Compare(key, ScalarMustParse(end).Bytes()) > 0
+	}
+	if b.lower {
+		return false
+	}
+	c := bytes.Compare(key, value.Bytes())
+	return c > 0 || (c == 0 && !b.inclusive)
+}
+
+// compare compares the key with the (transformed) value.
+// It returns false if the key can't be a value of the same type, like the empty key of documents without a value.
+func (b boundPart) compare(key Key, transform Transform) (int, bool) {
+	value := b.transformed(transform)
+	switch v := value.value.(type) {
+	case float64:
+		if len(key) != 8 {
+			return 0, false
+		}
+		f := math.Float64frombits(binary.BigEndian.Uint64(key))
+		switch {
+		case math.IsNaN(f):
+			return 0, false
+		case f < v:
+			return -1, true
+		case f > v:
+			return 1, true
+		}
+		return 0, true
+	case bool:
+		if len(key) != 1 {
+			return 0, false
+		}
+	default:
+		if len(key) == 0 {
+			return 0, false
+		}
+	}
+	return bytes.Compare(key, value.Bytes()), true
+}
+
+// transformed returns the value with the transform applied
+func (b boundPart) transformed(transform Transform) Scalar {
+	if transform != nil {
+		return transform(b.value)
+	}
+	return b.value
+}
+
+// conditionOnValue only matches values of the same type as the bound
+func (b boundPart) conditionOnValue(value Scalar) bool {
+	switch b.value.value.(type) {
+	case float64:
+		if _, ok := value.value.(float64); !ok {
+			return false
+		}
+	case bool:
+		if _, ok := value.value.(bool); !ok {
+			return false
+		}
+	case string:
+		if _, ok := value.value.(string); !ok {
+			return false
+		}
+	}
+	return b.Condition(value.Bytes(), nil)
+}
+
+// rangeEnd is implemented by query parts that don't match every key between the key they seek to and their last match.
+// For other query parts, the first key that doesn't match ends the iteration over an index.
+type rangeEnd interface {
+	// pastEnd returns true if the key comes after all keys that match
+	pastEnd(key Key, transform Transform) bool
+}
+
+// valueCondition is implemented by query parts that depend on the type of a value, which isn't stored in the keys of an index.
+// The values of the documents found through an index are checked again.
+type valueCondition interface {
+	// conditionOnValue returns true if the value of a document falls within this condition
+	conditionOnValue(value Scalar) bool
+}
+
+// conditionOnValue returns true if the value of a document falls within the condition of the query part
+func conditionOnValue(part QueryPart, value Scalar) bool {
+	if vc, ok := part.(valueCondition); ok {
+		return vc.conditionOnValue(value)
+	}
+	return part.Condition(value.Bytes(), nil)
+}
+
 type prefixPart struct {
 	termPath TermPath
 	value    Scalar
@@ -20,6 +20,7 @@
 package goauld
 
 import (
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -72,6 +73,34 @@ func TestEq(t *testing.T) {
 	})
 }
 
+func TestIsType(t *testing.T) {
+	qp := IsType("http://schema.org/Person")
+
+	t.Run("ok - TermPath", func(t *testing.T) {
+		assert.True(t, qp.TermPath().Equals(NewTermPath(TypeTerm)))
+	})
+
+	t.Run("ok - condition true", func(t *testing.T) {
+		c := qp.Condition(Key("http://schema.org/Person"), nil)
+
+		assert.True(t, c)
+	})
+}
+
+func TestInGraph(t *testing.T) {
+	qp := InGraph("http://example.com/graph")
+
+	t.Run("ok - TermPath", func(t *testing.T) {
+		assert.True(t, qp.TermPath().Equals(NewTermPath(GraphTerm("http://example.com/graph"))))
+	})
+
+	t.Run("ok - condition true", func(t *testing.T) {
+		c := qp.Condition(Key("http://example.com/graph"), nil)
+
+		assert.True(t, c)
+	})
+}
+
 func TestRange(t *testing.T) {
 	qp := Range(testTermPath, ScalarMustParse("a"), ScalarMustParse("b"))
 
@@ -110,6 +139,117 @@ func TestRange(t *testing.T) {
 	})
 }
 
+func TestGreaterThan(t *testing.T) {
+	qp := GreaterThan(testTermPath, ScalarMustParse("b"))
+
+	t.Run("ok - seek", func(t *testing.T) {
+		assert.Equal(t, "b", qp.Seek().value)
+	})
+
+	t.Run("ok - condition", func(t *testing.T) {
+		assert.False(t, qp.Condition(Key("a"), nil))
+		assert.False(t, qp.Condition(Key("b"), nil))
+		assert.True(t, qp.Condition(Key("bb"), nil))
+		assert.True(t, GreaterThanOrEqual(testTermPath, ScalarMustParse("b")).Condition(Key("b"), nil))
+	})
+
+	t.Run("ok - condition with transform", func(t *testing.T) {
+		qp := GreaterThan(testTermPath, ScalarMustParse("B"))
+
+		assert.True(t, qp.Condition(Key("c"), ToLower))
+	})
+
+	t.Run("ok - equal key doesn't end the range", func(t *testing.T) {
+		assert.False(t, qp.(rangeEnd).pastEnd(Key("b"), nil))
+	})
+}
+
+func TestGreaterThan_numbers(t *testing.T) {
+	key := func(f float64) Key {
+		return ScalarMustParse(f).Bytes()
+	}
+
+	t.Run("ok - positive bound", func(t *testing.T) {
+		qp := GreaterThan(testTermPath, ScalarMustParse(80.0))
+
+		assert.Equal(t, key(80), Key(qp.Seek().Bytes()))
+		assert.True(t, qp.Condition(key(90), nil))
+		assert.False(t, qp.Condition(key(80), nil))
+		assert.False(t, qp.Condition(key(-5), nil))
+		assert.False(t, qp.Condition(Key("heavy"), nil))
+		// the negative numbers follow +Inf
+		assert.False(t, qp.(rangeEnd).pastEnd(key(math.Inf(1)), nil))
+		assert.True(t, qp.(rangeEnd).pastEnd(key(-5), nil))
+	})
+
+	t.Run("ok - negative bound", func(t *testing.T) {
+		qp := GreaterThan(testTermPath, ScalarMustParse(-10.0))
+
+		assert.Equal(t, []byte{}, qp.Seek().Bytes())
+		assert.True(t, qp.Condition(key(1), nil))
+		assert.True(t, qp.Condition(key(-5), nil))
+		assert.False(t, qp.Condition(key(-20), nil))
+		assert.False(t, qp.(rangeEnd).pastEnd(key(-10), nil))
+		assert.True(t, qp.(rangeEnd).pastEnd(key(-20), nil))
+	})
+
+	t.Run("ok - only numbers match the value of a document", func(t *testing.T) {
+		qp := GreaterThan(testTermPath, ScalarMustParse(80.0))
+
+		assert.True(t, conditionOnValue(qp, ScalarMustParse(90.0)))
+		// 8 bytes, like a number
+		assert.True(t, qp.Condition(Key("heavyish"), nil))
+		assert.False(t, conditionOnValue(qp, ScalarMustParse("heavyish")))
+	})
+}
+
+func TestLessThan_numbers(t *testing.T) {
+	key := func(f float64) Key {
+		return ScalarMustParse(f).Bytes()
+	}
+
+	t.Run("ok - positive bound", func(t *testing.T) {
+		qp := LessThan(testTermPath, ScalarMustParse(80.0))
+
+		assert.Equal(t, []byte{}, qp.Seek().Bytes())
+		assert.True(t, qp.Condition(key(70), nil))
+		assert.True(t, qp.Condition(key(-5), nil))
+		assert.False(t, qp.Condition(key(90), nil))
+		assert.False(t, qp.(rangeEnd).pastEnd(key(90), nil))
+		assert.False(t, qp.(rangeEnd).pastEnd(key(math.Inf(-1)), nil))
+		assert.True(t, qp.(rangeEnd).pastEnd(Key("\xff\xf0\x00\x00\x00\x00\x00\x01"), nil))
+	})
+
+	t.Run("ok - negative bound", func(t *testing.T) {
+		qp := LessThanOrEqual(testTermPath, ScalarMustParse(-10.0))
+
+		assert.Equal(t, key(-10), Key(qp.Seek().Bytes()))
+		assert.True(t, qp.Condition(key(-10), nil))
+		assert.True(t, qp.Condition(key(-20), nil))
+		assert.False(t, qp.Condition(key(-5), nil))
+	})
+}
+
+func TestLessThan(t *testing.T) {
+	qp := LessThan(testTermPath, ScalarMustParse("b"))
+
+	t.Run("ok - seek from the first key", func(t *testing.T) {
+		assert.Equal(t, []byte{}, qp.Seek().Bytes())
+	})
+
+	t.Run("ok - condition", func(t *testing.T) {
+		assert.True(t, qp.Condition(Key("a"), nil))
+		assert.False(t, qp.Condition(Key("b"), nil))
+		assert.True(t, LessThanOrEqual(testTermPath, ScalarMustParse("b")).Condition(Key("b"), nil))
+	})
+
+	t.Run("ok - empty key doesn't match but doesn't end the range", func(t *testing.T) {
+		assert.False(t, qp.Condition(Key{}, nil))
+		assert.False(t, qp.(rangeEnd).pastEnd(Key{}, nil))
+		assert.True(t, qp.(rangeEnd).pastEnd(Key("b"), nil))
+	})
+}
+
 func TestPrefix(t *testing.T) {
 	qp := Prefix(testTermPath, testSearchTerm)
 
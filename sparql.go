@@ -0,0 +1,489 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidSPARQL is returned when a SPARQL query can't be parsed or uses unsupported features
+var ErrInvalidSPARQL = errors.New("invalid SPARQL query")
+
+const (
+	rdfTypeIRI = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+	xsdPrefix  = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// SPARQLQuery is a parsed SPARQL SELECT query, see ParseSPARQL.
+type SPARQLQuery struct {
+	// Variables are the projected variables without the leading '?', nil for SELECT *
+	Variables []string
+	where     patternGroup
+	optionals []patternGroup
+	order     []orderCondition
+	// limit is the maximum number of solutions, -1 means no limit
+	limit int
+}
+
+// patternGroup is a basic graph pattern with filters.
+// The patterns are ordered so a subject variable is bound before it's used, when possible.
+type patternGroup struct {
+	patterns []triplePattern
+	filters  []sparqlExpression
+}
+
+type triplePattern struct {
+	subject   sparqlTerm
+	predicate sparqlTerm
+	object    sparqlTerm
+}
+
+type orderCondition struct {
+	variable   string
+	descending bool
+}
+
+type sparqlTermKind int
+
+const (
+	sparqlVariable sparqlTermKind = iota
+	sparqlIRI
+	sparqlLiteral
+)
+
+// sparqlTerm is a variable (by name), an IRI or a literal
+type sparqlTerm struct {
+	kind  sparqlTermKind
+	name  string
+	value Scalar
+}
+
+// ParseSPARQL parses a subset of SPARQL SELECT queries:
+//
+//	PREFIX schema: <http://schema.org/>
+//	SELECT ?name ?employer
+//	WHERE {
+//	  ?person a schema:Person ;
+//	          schema:name ?name .
+//	  OPTIONAL { ?person schema:worksFor ?org . ?org schema:name ?employer }
+//	  FILTER (?name != "John Doe" && BOUND(?employer))
+//	}
+//	ORDER BY DESC(?name)
+//	LIMIT 10
+//
+// Supported are PREFIX declarations, basic graph patterns with ';' and ',' lists, OPTIONAL groups without nesting,
+// FILTER with comparisons (=, !=, <, <=, >, >=), BOUND, &&, || and !, ORDER BY with ASC and DESC, and LIMIT.
+// Predicates must be IRIs, language tags of literals are ignored and typed literals with an XSD numeric or boolean datatype are converted.
+// Errors wrap ErrInvalidSPARQL and contain the line and column of the offending token.
+func ParseSPARQL(text string) (*SPARQLQuery, error) {
+	tp, err := newTokenParser(text, ErrInvalidSPARQL)
+	if err != nil {
+		return nil, err
+	}
+	p := sparqlParser{tokenParser: tp}
+	return p.parseQuery()
+}
+
+// sparqlParser parses the tokens of a SPARQL query
+type sparqlParser struct {
+	*tokenParser
+}
+
+func (p *sparqlParser) parseQuery() (*SPARQLQuery, error) {
+	if err := p.parsePrefixes(); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	query := &SPARQLQuery{limit: -1}
+	if p.isKeyword("DISTINCT") || p.isKeyword("REDUCED") {
+		return nil, p.errorf(p.peek(), "%s is not supported", strings.ToUpper(p.peek().text))
+	}
+	if !p.acceptPunctuation("*") {
+		for p.peek().kind == tokenVariable {
+			query.Variables = append(query.Variables, p.next().text)
+		}
+		if len(query.Variables) == 0 {
+			return nil, p.unexpected(p.peek(), "a variable or '*'")
+		}
+	}
+
+	p.acceptKeyword("WHERE")
+	var err error
+	if query.where, query.optionals, err = p.parseGroup(true); err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err = p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if query.order, err = p.parseOrder(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		token := p.next()
+		limit, err := strconv.Atoi(token.text)
+		if token.kind != tokenNumber || err != nil || limit < 0 {
+			return nil, p.unexpected(token, "a non-negative integer")
+		}
+		query.limit = limit
+	}
+
+	if token := p.peek(); token.kind != tokenEOF {
+		return nil, p.unexpected(token, "end of query")
+	}
+
+	bound := map[string]bool{}
+	query.where.patterns = orderPatterns(query.where.patterns, bound)
+	for j := range query.optionals {
+		query.optionals[j].patterns = orderPatterns(query.optionals[j].patterns, copyBound(bound))
+	}
+	return query, nil
+}
+
+// parseGroup parses a group between braces, optional groups are only allowed at the top level
+func (p *sparqlParser) parseGroup(topLevel bool) (patternGroup, []patternGroup, error) {
+	group := patternGroup{}
+	var optionals []patternGroup
+	if err := p.expectPunctuation("{"); err != nil {
+		return group, nil, err
+	}
+	for !p.acceptPunctuation("}") {
+		switch {
+		case p.acceptPunctuation("."):
+		case p.isKeyword("FILTER"):
+			p.next()
+			filter, err := p.parsePrimary()
+			if err != nil {
+				return group, nil, err
+			}
+			group.filters = append(group.filters, filter)
+		case p.isKeyword("OPTIONAL"):
+			if !topLevel {
+				return group, nil, p.errorf(p.peek(), "nested OPTIONAL is not supported")
+			}
+			p.next()
+			optional, _, err := p.parseGroup(false)
+			if err != nil {
+				return group, nil, err
+			}
+			optionals = append(optionals, optional)
+		case p.peek().kind == tokenEOF:
+			return group, nil, p.unexpected(p.peek(), "'}'")
+		default:
+			patterns, err := p.parseTriples()
+			if err != nil {
+				return group, nil, err
+			}
+			group.patterns = append(group.patterns, patterns...)
+		}
+	}
+	return group, optionals, nil
+}
+
+// parseTriples parses a subject with a list of predicates and objects
+func (p *sparqlParser) parseTriples() ([]triplePattern, error) {
+	subject, err := p.parseTerm(false)
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []triplePattern
+	for {
+		predicate, err := p.parsePredicate()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			object, err := p.parseTerm(true)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, triplePattern{subject: subject, predicate: predicate, object: object})
+			if !p.acceptPunctuation(",") {
+				break
+			}
+		}
+		if !p.acceptPunctuation(";") {
+			return patterns, nil
+		}
+		// a trailing semicolon is allowed
+		if p.isPunctuation(".") || p.isPunctuation("}") {
+			return patterns, nil
+		}
+	}
+}
+
+func (p *sparqlParser) parsePredicate() (sparqlTerm, error) {
+	token := p.peek()
+	switch {
+	case token.kind == tokenWord && token.text == "a":
+		p.next()
+		return sparqlTerm{kind: sparqlIRI, value: ScalarMustParse(rdfTypeIRI)}, nil
+	case token.kind == tokenVariable:
+		return sparqlTerm{}, p.errorf(token, "variable predicates are not supported")
+	case token.kind == tokenIRI || token.kind == tokenPrefixedName:
+		return p.parseTerm(false)
+	}
+	return sparqlTerm{}, p.unexpected(token, "a predicate IRI")
+}
+
+// parseTerm parses a variable, IRI or, if allowed, a literal
+func (p *sparqlParser) parseTerm(allowLiteral bool) (sparqlTerm, error) {
+	token := p.peek()
+	switch token.kind {
+	case tokenVariable:
+		p.next()
+		return sparqlTerm{kind: sparqlVariable, name: token.text}, nil
+	case tokenIRI, tokenPrefixedName:
+		iri, err := p.parseIRI()
+		if err != nil {
+			return sparqlTerm{}, err
+		}
+		return sparqlTerm{kind: sparqlIRI, value: ScalarMustParse(iri)}, nil
+	}
+
+	if !allowLiteral {
+		return sparqlTerm{}, p.unexpected(token, "a variable or IRI")
+	}
+	switch {
+	case token.kind == tokenString:
+		p.next()
+		return p.parseLiteral(token)
+	case token.kind == tokenNumber:
+		p.next()
+		f, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return sparqlTerm{}, p.errorf(token, "invalid number %q", token.text)
+		}
+		return sparqlTerm{kind: sparqlLiteral, value: ScalarMustParse(f)}, nil
+	case token.kind == tokenWord && (token.text == "true" || token.text == "false"):
+		p.next()
+		return sparqlTerm{kind: sparqlLiteral, value: ScalarMustParse(token.text == "true")}, nil
+	}
+	return sparqlTerm{}, p.unexpected(token, "a variable, IRI or literal")
+}
+
+// parseLiteral parses the optional language tag or datatype of a string literal
+func (p *sparqlParser) parseLiteral(token lexToken) (sparqlTerm, error) {
+	literal := sparqlTerm{kind: sparqlLiteral, value: ScalarMustParse(token.text)}
+	if p.peek().kind == tokenAtWord {
+		p.next()
+		return literal, nil
+	}
+	if !p.acceptPunctuation("^^") {
+		return literal, nil
+	}
+
+	datatype, err := p.parseIRI()
+	if err != nil {
+		return sparqlTerm{}, err
+	}
+	switch strings.TrimPrefix(datatype, xsdPrefix) {
+	case "boolean":
+		b, err := strconv.ParseBool(token.text)
+		if err != nil {
+			return sparqlTerm{}, p.errorf(token, "invalid boolean %q", token.text)
+		}
+		literal.value = ScalarMustParse(b)
+	case "integer", "int", "long", "short", "decimal", "double", "float", "nonNegativeInteger", "positiveInteger":
+		f, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return sparqlTerm{}, p.errorf(token, "invalid number %q", token.text)
+		}
+		literal.value = ScalarMustParse(f)
+	}
+	return literal, nil
+}
+
+func (p *sparqlParser) parseOrder() ([]orderCondition, error) {
+	var conditions []orderCondition
+	for {
+		token := p.peek()
+		switch {
+		case token.kind == tokenVariable:
+			p.next()
+			conditions = append(conditions, orderCondition{variable: token.text})
+			continue
+		case p.isKeyword("ASC") || p.isKeyword("DESC"):
+			p.next()
+			if err := p.expectPunctuation("("); err != nil {
+				return nil, err
+			}
+			variable := p.next()
+			if variable.kind != tokenVariable {
+				return nil, p.unexpected(variable, "a variable")
+			}
+			if err := p.expectPunctuation(")"); err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, orderCondition{variable: variable.text, descending: strings.EqualFold(token.text, "DESC")})
+			continue
+		}
+		if len(conditions) == 0 {
+			return nil, p.unexpected(token, "a variable, ASC or DESC")
+		}
+		return conditions, nil
+	}
+}
+
+// parseOr parses expressions combined with ||
+func (p *sparqlParser) parseOr() (sparqlExpression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptPunctuation("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpression{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses expressions combined with &&
+func (p *sparqlParser) parseAnd() (sparqlExpression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptPunctuation("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpression{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *sparqlParser) parseUnary() (sparqlExpression, error) {
+	if p.acceptPunctuation("!") {
+		expression, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpression{expression: expression}, nil
+	}
+	if p.isPunctuation("(") || p.isKeyword("BOUND") {
+		return p.parsePrimary()
+	}
+	return p.parseComparison()
+}
+
+// parsePrimary parses an expression between parentheses or a BOUND call, the forms allowed after FILTER
+func (p *sparqlParser) parsePrimary() (sparqlExpression, error) {
+	if p.acceptKeyword("BOUND") {
+		if err := p.expectPunctuation("("); err != nil {
+			return nil, err
+		}
+		variable := p.next()
+		if variable.kind != tokenVariable {
+			return nil, p.unexpected(variable, "a variable")
+		}
+		return boundExpression{variable: variable.text}, p.expectPunctuation(")")
+	}
+
+	if err := p.expectPunctuation("("); err != nil {
+		return nil, err
+	}
+	expression, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	return expression, p.expectPunctuation(")")
+}
+
+func (p *sparqlParser) parseComparison() (sparqlExpression, error) {
+	left, err := p.parseTerm(true)
+	if err != nil {
+		return nil, err
+	}
+	operator := p.next()
+	switch operator.text {
+	case "=", "!=", "<", "<=", ">", ">=":
+		if operator.kind != tokenPunctuation {
+			break
+		}
+		right, err := p.parseTerm(true)
+		if err != nil {
+			return nil, err
+		}
+		return comparison{operator: operator.text, left: left, right: right}, nil
+	}
+	return nil, p.unexpected(operator, "a comparison operator")
+}
+
+// orderPatterns orders the patterns so the subject of a pattern is bound by an earlier pattern, if another pattern binds it.
+// Subjects that are not bound by any pattern match the top-level nodes of a document.
+// The bound variables are updated with the variables of the patterns.
+func orderPatterns(patterns []triplePattern, bound map[string]bool) []triplePattern {
+	remaining := append([]triplePattern{}, patterns...)
+	ordered := make([]triplePattern, 0, len(patterns))
+	for len(remaining) > 0 {
+		next := 0
+		for j, pattern := range remaining {
+			if isReady(pattern, remaining, bound) {
+				next = j
+				break
+			}
+		}
+		pattern := remaining[next]
+		remaining = append(remaining[:next], remaining[next+1:]...)
+		ordered = append(ordered, pattern)
+		for _, term := range []sparqlTerm{pattern.subject, pattern.object} {
+			if term.kind == sparqlVariable {
+				bound[term.name] = true
+			}
+		}
+	}
+	return ordered
+}
+
+// isReady returns true if the subject of the pattern is a constant, bound or not bound by any of the remaining patterns
+func isReady(pattern triplePattern, remaining []triplePattern, bound map[string]bool) bool {
+	subject := pattern.subject
+	if subject.kind != sparqlVariable || bound[subject.name] {
+		return true
+	}
+	for _, other := range remaining {
+		if other.object.kind == sparqlVariable && other.object.name == subject.name && other != pattern {
+			return false
+		}
+	}
+	return true
+}
+
+func copyBound(bound map[string]bool) map[string]bool {
+	result := make(map[string]bool, len(bound))
+	for name := range bound {
+		result[name] = true
+	}
+	return result
+}
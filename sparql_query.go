@@ -0,0 +1,572 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// Solution binds the variables of a SPARQL query by name, without the leading '?'.
+// IRIs are bound as string, variables that aren't bound by an OPTIONAL group are absent.
+type Solution map[string]Scalar
+
+// errLimitReached stops the iteration over documents when enough solutions have been found
+var errLimitReached = errors.New("limit reached")
+
+// Select executes the SPARQL query on the documents of the collection within a single read transaction.
+// Every document is queried as a separate graph: solutions don't combine nodes of different documents.
+// Subjects match the nodes of a document: the top-level nodes, the nodes of its named graphs and the nodes embedded in them.
+// Descriptions of the same @id are merged, so a node reference is resolved to the node within the document.
+// Constant objects, types and equality filters are translated to query parts, so the query planner can use indices
+// on NewTermPath(AllGraphsTerm, DescendantTerm, predicate). Documents are scanned when there are no such parts.
+func (c *collection) Select(ctx context.Context, query *SPARQLQuery) ([]Solution, error) {
+	var solutions []Solution
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		solutions, err = c.selectTx(ctx, tx, query)
+		return err
+	})
+	return solutions, err
+}
+
+func (c *collection) selectTx(ctx context.Context, tx *bbolt.Tx, query *SPARQLQuery) ([]Solution, error) {
+	expand := c.expander(tx)
+	bindings := make([]sparqlBinding, 0)
+	walker := func(ref Reference, doc []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		expanded, err := expand(ref, doc)
+		if err != nil {
+			return err
+		}
+		bindings = append(bindings, query.evaluate(expanded)...)
+		// without ordering, the first solutions are returned
+		if query.limit >= 0 && len(query.order) == 0 && len(bindings) >= query.limit {
+			return errLimitReached
+		}
+		return nil
+	}
+
+	if err := c.iterateOrScan(tx, query.prefilter(), walker); err != nil && err != errLimitReached {
+		return nil, err
+	}
+
+	if len(query.order) > 0 {
+		sort.SliceStable(bindings, func(i, j int) bool {
+			return query.less(bindings[i], bindings[j])
+		})
+	}
+	if query.limit >= 0 && len(bindings) > query.limit {
+		bindings = bindings[:query.limit]
+	}
+
+	solutions := make([]Solution, len(bindings))
+	for j, binding := range bindings {
+		solutions[j] = query.project(binding)
+	}
+	return solutions, nil
+}
+
+// sparqlValue is a value bound to a variable, node is set when the value is a node object
+type sparqlValue struct {
+	scalar Scalar
+	iri    bool
+	node   map[string]interface{}
+}
+
+func (v sparqlValue) equals(other sparqlValue) bool {
+	return v.iri == other.iri && v.scalar.value == other.scalar.value
+}
+
+// termValue returns the value of a constant term
+func termValue(term sparqlTerm) sparqlValue {
+	return sparqlValue{scalar: term.value, iri: term.kind == sparqlIRI}
+}
+
+// sparqlBinding maps variable names to values, it's copied when extended
+type sparqlBinding map[string]sparqlValue
+
+func (b sparqlBinding) with(name string, value sparqlValue) sparqlBinding {
+	result := make(sparqlBinding, len(b)+1)
+	for k, v := range b {
+		result[k] = v
+	}
+	result[name] = value
+	return result
+}
+
+// resolve returns the value of the term, it returns false for unbound variables
+func (b sparqlBinding) resolve(term sparqlTerm) (sparqlValue, bool) {
+	if term.kind == sparqlVariable {
+		value, ok := b[term.name]
+		return value, ok
+	}
+	return termValue(term), true
+}
+
+// evaluate returns the solutions of the query for a single expanded document
+func (q *SPARQLQuery) evaluate(expanded []interface{}) []sparqlBinding {
+	e := &sparqlEvaluator{byID: map[string]map[string]interface{}{}, labels: map[uintptr]string{}}
+	e.collectGraph(expanded)
+
+	solutions := e.match(q.where.patterns, []sparqlBinding{{}})
+	for _, optional := range q.optionals {
+		result := make([]sparqlBinding, 0, len(solutions))
+		for _, solution := range solutions {
+			extended := filterBindings(e.match(optional.patterns, []sparqlBinding{solution}), optional.filters)
+			if len(extended) == 0 {
+				result = append(result, solution)
+			} else {
+				result = append(result, extended...)
+			}
+		}
+		solutions = result
+	}
+	return filterBindings(solutions, q.where.filters)
+}
+
+// project returns the solution with the projected variables
+func (q *SPARQLQuery) project(binding sparqlBinding) Solution {
+	solution := Solution{}
+	for name, value := range binding {
+		solution[name] = value.scalar
+	}
+	if q.Variables == nil {
+		return solution
+	}
+	projected := Solution{}
+	for _, name := range q.Variables {
+		if value, ok := solution[name]; ok {
+			projected[name] = value
+		}
+	}
+	return projected
+}
+
+// less compares bindings by the order conditions, unbound variables come first
+func (q *SPARQLQuery) less(a, b sparqlBinding) bool {
+	for _, condition := range q.order {
+		va, okA := a[condition.variable]
+		vb, okB := b[condition.variable]
+		var result int
+		switch {
+		case !okA && !okB:
+			continue
+		case !okA:
+			result = -1
+		case !okB:
+			result = 1
+		default:
+			result = orderValues(va.scalar.value, vb.scalar.value)
+		}
+		if condition.descending {
+			result = -result
+		}
+		if result != 0 {
+			return result < 0
+		}
+	}
+	return false
+}
+
+// orderValues compares values for ordering, values of different types are ordered by type: booleans, numbers, strings
+func orderValues(a, b interface{}) int {
+	if result, ok := compareValues(a, b); ok {
+		return result
+	}
+	return typeRank(a) - typeRank(b)
+}
+
+func typeRank(value interface{}) int {
+	switch value.(type) {
+	case bool:
+		return 0
+	case float64:
+		return 1
+	}
+	return 2
+}
+
+// compareValues compares values of the same type, it returns false if the values can't be compared
+func compareValues(a, b interface{}) (int, bool) {
+	switch va := a.(type) {
+	case float64:
+		if vb, ok := b.(float64); ok {
+			switch {
+			case va < vb:
+				return -1, true
+			case va > vb:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if vb, ok := b.(string); ok {
+			return strings.Compare(va, vb), true
+		}
+	case bool:
+		if vb, ok := b.(bool); ok {
+			switch {
+			case va == vb:
+				return 0, true
+			case vb:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// prefilter returns a query with the conditions every matching document must meet, nil if there are none.
+// A subject may be any node of the document, so the TermPaths start at every node instead of following the patterns.
+func (q *SPARQLQuery) prefilter() Query {
+	// the TermPath to the values of a variable that is bound as object
+	paths := map[string]TermPath{}
+	var parts []QueryPart
+	for _, pattern := range q.where.patterns {
+		subjectPath := NewTermPath(AllGraphsTerm, DescendantTerm)
+		if pattern.subject.kind != sparqlVariable {
+			subjectPath = appendTerm(subjectPath, IDFilterTerm(pattern.subject.value.value.(string)))
+		}
+
+		objectPath := appendTerm(subjectPath, pattern.predicate.value.value.(string))
+		if pattern.predicate.value.value == rdfTypeIRI {
+			objectPath = appendTerm(subjectPath, TypeTerm)
+		}
+		switch pattern.object.kind {
+		case sparqlVariable:
+			if _, ok := paths[pattern.object.name]; !ok {
+				paths[pattern.object.name] = objectPath
+			}
+		default:
+			parts = append(parts, Eq(objectPath, pattern.object.value))
+		}
+	}
+
+	for _, filter := range q.where.filters {
+		parts = append(parts, equalityParts(filter, paths)...)
+	}
+
+	if len(parts) == 0 {
+		return nil
+	}
+	query := New(parts[0])
+	for _, part := range parts[1:] {
+		query = query.And(part)
+	}
+	return query
+}
+
+// equalityParts returns Eq query parts for the comparisons of variables with constants that must hold for the filter to pass
+func equalityParts(expression sparqlExpression, paths map[string]TermPath) []QueryPart {
+	switch e := expression.(type) {
+	case andExpression:
+		return append(equalityParts(e.left, paths), equalityParts(e.right, paths)...)
+	case comparison:
+		if e.operator != "=" {
+			return nil
+		}
+		variable, constant := e.left, e.right
+		if variable.kind != sparqlVariable {
+			variable, constant = constant, variable
+		}
+		if variable.kind != sparqlVariable || constant.kind == sparqlVariable {
+			return nil
+		}
+		if path, ok := paths[variable.name]; ok {
+			return []QueryPart{Eq(path, constant.value)}
+		}
+	}
+	return nil
+}
+
+// appendTerm returns a new TermPath with the term added
+func appendTerm(termPath TermPath, term string) TermPath {
+	terms := make([]string, len(termPath.Terms), len(termPath.Terms)+1)
+	copy(terms, termPath.Terms)
+	return NewTermPath(append(terms, term)...)
+}
+
+// sparqlEvaluator matches patterns against the nodes of a single expanded document
+type sparqlEvaluator struct {
+	// nodes contains the nodes of the document in document order, a node with an @id is only included once
+	nodes []sparqlValue
+	// byID contains the merged descriptions of the nodes with an @id, by @id
+	byID map[string]map[string]interface{}
+	// labels contains the blank node labels of nodes without @id, by node
+	labels map[uintptr]string
+}
+
+// collectGraph collects the given nodes and the nodes of their named graphs, like the AllGraphsTerm
+func (e *sparqlEvaluator) collectGraph(nodes []interface{}) {
+	for _, n := range nodes {
+		if node, ok := n.(map[string]interface{}); ok {
+			e.collectNode(node)
+			if graph, ok := node["@graph"].([]interface{}); ok {
+				e.collectGraph(graph)
+			}
+		}
+	}
+}
+
+// collectNode collects the node and the nodes embedded in its properties, like the DescendantTerm.
+// The properties and types of nodes with the same @id are merged, as when the document is flattened.
+// Value objects and lists are skipped.
+func (e *sparqlEvaluator) collectNode(node map[string]interface{}) {
+	if _, ok := node["@value"]; ok {
+		return
+	}
+	if _, ok := node["@list"]; ok {
+		return
+	}
+
+	terms := make([]string, 0, len(node))
+	for term := range node {
+		if !strings.HasPrefix(term, "@") {
+			terms = append(terms, term)
+		}
+	}
+	sort.Strings(terms)
+
+	if id, ok := node["@id"].(string); ok {
+		merged, exists := e.byID[id]
+		if !exists {
+			merged = map[string]interface{}{"@id": id}
+			e.byID[id] = merged
+			e.nodes = append(e.nodes, sparqlValue{scalar: ScalarMustParse(id), iri: true, node: merged})
+		}
+		for _, term := range append([]string{"@type"}, terms...) {
+			if values, ok := node[term].([]interface{}); ok {
+				existing, _ := merged[term].([]interface{})
+				merged[term] = append(existing, values...)
+			}
+		}
+	} else {
+		e.nodes = append(e.nodes, e.nodeValue(node))
+	}
+
+	for _, term := range terms {
+		values, _ := node[term].([]interface{})
+		for _, v := range values {
+			if object, ok := v.(map[string]interface{}); ok {
+				e.collectNode(object)
+			}
+		}
+	}
+}
+
+// nodeValue returns the value of a node object, a node with an @id is resolved to its merged description.
+// Nodes without @id get a blank node label that is unique within the document.
+func (e *sparqlEvaluator) nodeValue(node map[string]interface{}) sparqlValue {
+	id, ok := node["@id"].(string)
+	if ok {
+		if merged, exists := e.byID[id]; exists {
+			node = merged
+		}
+		return sparqlValue{scalar: ScalarMustParse(id), iri: true, node: node}
+	}
+	key := reflect.ValueOf(node).Pointer()
+	if id, ok = e.labels[key]; !ok {
+		id = fmt.Sprintf("_:b%d", len(e.labels))
+		e.labels[key] = id
+	}
+	return sparqlValue{scalar: ScalarMustParse(id), iri: true, node: node}
+}
+
+// match returns the bindings extended with the solutions of the patterns
+func (e *sparqlEvaluator) match(patterns []triplePattern, bindings []sparqlBinding) []sparqlBinding {
+	for _, pattern := range patterns {
+		next := make([]sparqlBinding, 0)
+		for _, binding := range bindings {
+			next = append(next, e.matchPattern(pattern, binding)...)
+		}
+		bindings = next
+	}
+	return bindings
+}
+
+func (e *sparqlEvaluator) matchPattern(pattern triplePattern, binding sparqlBinding) []sparqlBinding {
+	var subjects []sparqlValue
+	subject, bound := binding.resolve(pattern.subject)
+	switch {
+	case bound && pattern.subject.kind == sparqlVariable:
+		subjects = []sparqlValue{subject}
+	case bound:
+		for _, node := range e.nodes {
+			if node.equals(subject) {
+				subjects = append(subjects, node)
+			}
+		}
+	default:
+		subjects = e.nodes
+	}
+
+	result := make([]sparqlBinding, 0)
+	for _, s := range subjects {
+		if s.node == nil {
+			continue
+		}
+		b := binding
+		if !bound {
+			b = b.with(pattern.subject.name, s)
+		}
+		for _, object := range e.objects(s.node, pattern.predicate.value.value.(string)) {
+			if value, ok := b.resolve(pattern.object); ok {
+				if value.equals(object) {
+					result = append(result, b)
+				}
+				continue
+			}
+			result = append(result, b.with(pattern.object.name, object))
+		}
+	}
+	return result
+}
+
+// objects returns the values of the predicate of the node, lists are not supported
+func (e *sparqlEvaluator) objects(node map[string]interface{}, predicate string) []sparqlValue {
+	var result []sparqlValue
+	if predicate == rdfTypeIRI {
+		types, _ := node["@type"].([]interface{})
+		for _, t := range types {
+			if typeIRI, ok := t.(string); ok {
+				result = append(result, sparqlValue{scalar: ScalarMustParse(typeIRI), iri: true})
+			}
+		}
+		return result
+	}
+
+	values, _ := node[predicate].([]interface{})
+	for _, v := range values {
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, ok := object["@value"]; ok {
+			if scalar, err := ScalarParse(value); err == nil {
+				result = append(result, sparqlValue{scalar: scalar})
+			}
+			continue
+		}
+		if _, ok := object["@list"]; ok {
+			continue
+		}
+		result = append(result, e.nodeValue(object))
+	}
+	return result
+}
+
+// sparqlExpression is a FILTER expression, errors such as unbound variables evaluate to false
+type sparqlExpression interface {
+	evaluate(binding sparqlBinding) bool
+}
+
+type andExpression struct {
+	left, right sparqlExpression
+}
+
+func (e andExpression) evaluate(binding sparqlBinding) bool {
+	return e.left.evaluate(binding) && e.right.evaluate(binding)
+}
+
+type orExpression struct {
+	left, right sparqlExpression
+}
+
+func (e orExpression) evaluate(binding sparqlBinding) bool {
+	return e.left.evaluate(binding) || e.right.evaluate(binding)
+}
+
+type notExpression struct {
+	expression sparqlExpression
+}
+
+func (e notExpression) evaluate(binding sparqlBinding) bool {
+	return !e.expression.evaluate(binding)
+}
+
+type boundExpression struct {
+	variable string
+}
+
+func (e boundExpression) evaluate(binding sparqlBinding) bool {
+	_, ok := binding[e.variable]
+	return ok
+}
+
+type comparison struct {
+	operator    string
+	left, right sparqlTerm
+}
+
+func (e comparison) evaluate(binding sparqlBinding) bool {
+	left, okLeft := binding.resolve(e.left)
+	right, okRight := binding.resolve(e.right)
+	if !okLeft || !okRight {
+		return false
+	}
+
+	switch e.operator {
+	case "=":
+		return left.equals(right)
+	case "!=":
+		return !left.equals(right)
+	}
+
+	result, ok := compareValues(left.scalar.value, right.scalar.value)
+	if !ok {
+		return false
+	}
+	switch e.operator {
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	}
+	return result >= 0
+}
+
+func filterBindings(bindings []sparqlBinding, filters []sparqlExpression) []sparqlBinding {
+	if len(filters) == 0 {
+		return bindings
+	}
+	result := make([]sparqlBinding, 0, len(bindings))
+outer:
+	for _, binding := range bindings {
+		for _, filter := range filters {
+			if !filter.evaluate(binding) {
+				continue outer
+			}
+		}
+		result = append(result, binding)
+	}
+	return result
+}
@@ -0,0 +1,321 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sparqlPrefix = "PREFIX schema: <http://schema.org/>\n"
+
+func TestParseSPARQL(t *testing.T) {
+	t.Run("ok - full query", func(t *testing.T) {
+		q, err := ParseSPARQL(sparqlPrefix + `
+SELECT ?name ?employer
+WHERE {
+  ?person a schema:Person ;
+          schema:name ?name .
+  OPTIONAL { ?person schema:worksFor ?org . ?org schema:name ?employer }
+  FILTER (?name != "John Doe" && BOUND(?employer))
+}
+ORDER BY DESC(?name) ?employer
+LIMIT 10`)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, []string{"name", "employer"}, q.Variables)
+		assert.Len(t, q.where.patterns, 2)
+		assert.Equal(t, rdfTypeIRI, q.where.patterns[0].predicate.value.value)
+		assert.Len(t, q.where.filters, 1)
+		assert.Len(t, q.optionals, 1)
+		assert.Equal(t, []orderCondition{{variable: "name", descending: true}, {variable: "employer"}}, q.order)
+		assert.Equal(t, 10, q.limit)
+	})
+
+	t.Run("ok - literals", func(t *testing.T) {
+		q, err := ParseSPARQL(`SELECT * {
+  ?s <http://schema.org/a> "a\"b", 'c'@en, 1.5, -2, true, "80"^^<http://www.w3.org/2001/XMLSchema#integer>, "x"^^<http://example.com/type> .
+}`)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Nil(t, q.Variables)
+		assert.Equal(t, -1, q.limit)
+		var values []interface{}
+		for _, pattern := range q.where.patterns {
+			values = append(values, pattern.object.value.value)
+		}
+		assert.Equal(t, []interface{}{"a\"b", "c", 1.5, -2.0, true, 80.0, "x"}, values)
+	})
+
+	t.Run("ok - patterns are ordered by dependency", func(t *testing.T) {
+		q, _ := ParseSPARQL(`SELECT * { ?child <http://schema.org/name> ?name . ?parent <http://schema.org/children> ?child }`)
+
+		assert.Equal(t, "parent", q.where.patterns[0].subject.name)
+		assert.Equal(t, "child", q.where.patterns[1].subject.name)
+	})
+
+	errorCases := []struct {
+		name    string
+		query   string
+		message string
+	}{
+		{"no select", "ASK { ?s ?p ?o }", `line 1, column 1: expected SELECT, found "ASK"`},
+		{"unknown prefix", "SELECT * { ?s foaf:name ?o }", `line 1, column 15: unknown prefix "foaf"`},
+		{"variable predicate", "SELECT * {\n ?s ?p ?o }", "line 2, column 5: variable predicates are not supported"},
+		{"unterminated group", "SELECT * { ?s <http://schema.org/name> ?o", "line 1, column 42: expected '}', found end of query"},
+		{"unterminated string", `SELECT * { ?s <http://schema.org/name> "o }`, "line 1, column 40: unterminated string"},
+		{"literal subject", `SELECT * { "s" <http://schema.org/name> ?o }`, `line 1, column 12: expected a variable or IRI, found "\"s\""`},
+		{"distinct", "SELECT DISTINCT ?s { ?s <http://schema.org/name> ?o }", "line 1, column 8: DISTINCT is not supported"},
+		{"nested optional", "SELECT * { OPTIONAL { OPTIONAL { ?s <http://schema.org/name> ?o } } }", "line 1, column 23: nested OPTIONAL is not supported"},
+		{"filter without comparison", "SELECT * { ?s <http://schema.org/name> ?o FILTER (?o) }", `line 1, column 53: expected a comparison operator, found ")"`},
+		{"invalid limit", "SELECT * { ?s <http://schema.org/name> ?o } LIMIT 1.5", `line 1, column 51: expected a non-negative integer, found "1.5"`},
+		{"trailing tokens", "SELECT * { ?s <http://schema.org/name> ?o } }", `line 1, column 45: expected end of query, found "}"`},
+		{"unexpected character", "SELECT * { ?s <http://schema.org/name> ?o & }", `line 1, column 43: unexpected character '&'`},
+	}
+	for _, c := range errorCases {
+		t.Run("error - "+c.name, func(t *testing.T) {
+			_, err := ParseSPARQL(c.query)
+
+			assert.True(t, errors.Is(err, ErrInvalidSPARQL))
+			assert.EqualError(t, err, "invalid SPARQL query: "+c.message)
+		})
+	}
+}
+
+func TestCollection_Select(t *testing.T) {
+	ctx := context.Background()
+	c := createCollection(testDB(t))
+	_ = c.Add([]Document{jsonLdExample, jsonLdExample2, jsonLdTypedExample, jsonLdMultiTypeExample})
+	selectNames := func(t *testing.T, query string, variable string) []string {
+		q, err := ParseSPARQL(sparqlPrefix + query)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		solutions, err := c.Select(ctx, q)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		names := make([]string, 0)
+		for _, solution := range solutions {
+			if value, ok := solution[variable]; ok {
+				names = append(names, string(value.Bytes()))
+			} else {
+				names = append(names, "")
+			}
+		}
+		return names
+	}
+
+	t.Run("ok - basic graph pattern", func(t *testing.T) {
+		names := selectNames(t, `SELECT ?name { ?p schema:jobTitle "Professor" ; schema:name ?name }`, "name")
+
+		assert.Equal(t, []string{"Jane Doe"}, names)
+	})
+
+	t.Run("ok - nested nodes", func(t *testing.T) {
+		names := selectNames(t, `SELECT ?child { ?p schema:children ?c . ?c schema:name ?child }`, "child")
+
+		assert.Equal(t, []string{"John Doe"}, names)
+	})
+
+	t.Run("ok - type", func(t *testing.T) {
+		names := selectNames(t, `SELECT ?name { ?p a schema:Patient ; schema:name ?name }`, "name")
+
+		assert.Equal(t, []string{"Jack Doe"}, names)
+	})
+
+	t.Run("ok - optional", func(t *testing.T) {
+		names := selectNames(t, `SELECT ?name ?org {
+  ?p a schema:Person ; schema:name ?name .
+  OPTIONAL { ?p schema:worksFor ?o . ?o schema:name ?org }
+} ORDER BY ?name ?org`, "org")
+
+		assert.Equal(t, []string{"", "", "Nuts", "", ""}, names)
+	})
+
+	t.Run("ok - filter", func(t *testing.T) {
+		names := selectNames(t, `SELECT ?name { ?p schema:name ?name ; schema:weight ?w FILTER (?w > 80 || ?name = "Jane Doe") }`, "name")
+
+		assert.ElementsMatch(t, []string{"Jane Doe", "John Doe"}, names)
+	})
+
+	t.Run("ok - filter on an optional variable", func(t *testing.T) {
+		names := selectNames(t, `SELECT ?name {
+  ?p a schema:Person ; schema:name ?name .
+  OPTIONAL { ?p schema:weight ?w }
+  FILTER (!BOUND(?w))
+} ORDER BY ?name`, "name")
+
+		assert.Equal(t, []string{"Jack Doe", "Jane Doe", "John Doe"}, names)
+	})
+
+	t.Run("ok - order and limit", func(t *testing.T) {
+		names := selectNames(t, `SELECT ?name { ?p schema:name ?name } ORDER BY DESC(?name) LIMIT 2`, "name")
+
+		assert.Equal(t, []string{"Nuts", "John Doe"}, names)
+	})
+
+	t.Run("ok - limit without order", func(t *testing.T) {
+		names := selectNames(t, `SELECT ?name { ?p schema:name ?name } LIMIT 1`, "name")
+
+		assert.Len(t, names, 1)
+	})
+
+	t.Run("ok - select all variables", func(t *testing.T) {
+		q, _ := ParseSPARQL(sparqlPrefix + `SELECT * { ?p schema:jobTitle "Soldier" ; schema:weight ?w }`)
+
+		solutions, err := c.Select(ctx, q)
+
+		if !assert.NoError(t, err) || !assert.Len(t, solutions, 1) {
+			return
+		}
+		assert.Len(t, solutions[0], 2)
+		assert.Equal(t, ScalarMustParse(90.0), solutions[0]["w"])
+		assert.Equal(t, "_:b0", string(solutions[0]["p"].Bytes()))
+	})
+
+	t.Run("ok - within a transaction", func(t *testing.T) {
+		s := testStore(t)
+		_ = s.Collection("test").Add([]Document{jsonLdExample})
+		q, _ := ParseSPARQL(sparqlPrefix + `SELECT ?name { ?p schema:name ?name }`)
+
+		_ = s.View(func(tx ReadTx) error {
+			solutions, err := tx.Collection("test").Select(ctx, q)
+			assert.NoError(t, err)
+			assert.Len(t, solutions, 2)
+			return nil
+		})
+	})
+
+	t.Run("error - cancelled context", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		q, _ := ParseSPARQL(sparqlPrefix + `SELECT ?name { ?p schema:name ?name }`)
+
+		_, err := c.Select(cancelled, q)
+
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
+func TestCollection_SelectNodes(t *testing.T) {
+	ctx := context.Background()
+	flattened := []byte(`{"@context": {"schema": "http://schema.org/"}, "@graph": [
+  {"@id": "http://e/a", "schema:knows": {"@id": "http://e/b"}},
+  {"@id": "http://e/b", "schema:name": "Bee"}
+]}`)
+	embedded := []byte(`{"@context": {"schema": "http://schema.org/"}, "@id": "http://e/c",
+  "schema:knows": {"@id": "http://e/d", "schema:name": "Dee"},
+  "schema:follows": {"@id": "http://e/d", "schema:email": "dee@example.com"}
+}`)
+	selectAll := func(t *testing.T, c Collection, query string) []Solution {
+		q, err := ParseSPARQL(sparqlPrefix + query)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		solutions, err := c.Select(ctx, q)
+		assert.NoError(t, err)
+		return solutions
+	}
+
+	t.Run("ok - references within a flattened document", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.Add([]Document{flattened})
+
+		solutions := selectAll(t, c, `SELECT ?a ?n { ?a schema:knows ?b . ?b schema:name ?n }`)
+
+		if assert.Len(t, solutions, 1) {
+			assert.Equal(t, "http://e/a", string(solutions[0]["a"].Bytes()))
+			assert.Equal(t, "Bee", string(solutions[0]["n"].Bytes()))
+		}
+	})
+
+	t.Run("ok - constant subject that is an embedded node", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.Add([]Document{embedded})
+
+		solutions := selectAll(t, c, `SELECT ?n ?e { <http://e/d> schema:name ?n ; schema:email ?e }`)
+
+		if assert.Len(t, solutions, 1) {
+			assert.Equal(t, "Dee", string(solutions[0]["n"].Bytes()))
+			assert.Equal(t, "dee@example.com", string(solutions[0]["e"].Bytes()))
+		}
+	})
+
+	t.Run("ok - indexed value of a referenced node", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.Add([]Document{flattened, embedded})
+		_ = c.AddIndex(c.NewIndex("name", NewFieldIndexer(NewTermPath(AllGraphsTerm, DescendantTerm, "http://schema.org/name"))))
+		q, _ := ParseSPARQL(sparqlPrefix + `SELECT ?a { ?a schema:knows ?b . ?b schema:name "Bee" }`)
+		plan, _ := c.queryPlan(q.prefilter())
+
+		solutions, err := c.Select(ctx, q)
+
+		assert.IsType(t, resultScanQueryPlan{}, plan)
+		assert.NoError(t, err)
+		if assert.Len(t, solutions, 1) {
+			assert.Equal(t, "http://e/a", string(solutions[0]["a"].Bytes()))
+		}
+	})
+}
+
+func TestSPARQLQuery_prefilter(t *testing.T) {
+	t.Run("ok - constants, types and equality filters", func(t *testing.T) {
+		q, _ := ParseSPARQL(sparqlPrefix + `SELECT * {
+  ?p a schema:Person ; schema:children ?c .
+  ?c schema:name "John Doe" .
+  <http://example.com/nuts> schema:name ?n .
+  FILTER (?n = "Nuts" && ?c != "x")
+}`)
+
+		query := q.prefilter()
+
+		if !assert.NotNil(t, query) || !assert.Len(t, query.Parts(), 3) {
+			return
+		}
+		assert.True(t, query.Parts()[0].TermPath().Equals(NewTermPath(AllGraphsTerm, DescendantTerm, TypeTerm)))
+		assert.True(t, query.Parts()[1].TermPath().Equals(NewTermPath(AllGraphsTerm, DescendantTerm, "http://schema.org/name")))
+		assert.True(t, query.Parts()[2].TermPath().Equals(NewTermPath(AllGraphsTerm, DescendantTerm, IDFilterTerm("http://example.com/nuts"), "http://schema.org/name")))
+		assert.Equal(t, "Nuts", string(query.Parts()[2].Seek().Bytes()))
+	})
+
+	t.Run("ok - no conditions", func(t *testing.T) {
+		q, _ := ParseSPARQL(sparqlPrefix + `SELECT * { ?p schema:name ?n }`)
+
+		assert.Nil(t, q.prefilter())
+	})
+
+	t.Run("ok - uses an index", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.AddIndex(c.NewIndex("type", NewFieldIndexer(NewTermPath(AllGraphsTerm, DescendantTerm, TypeTerm))))
+		q, _ := ParseSPARQL(sparqlPrefix + `SELECT * { ?p a schema:Person }`)
+
+		plan, _ := c.queryPlan(q.prefilter())
+
+		assert.IsType(t, resultScanQueryPlan{}, plan)
+	})
+}
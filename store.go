@@ -20,18 +20,66 @@
 package goauld
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/piprate/json-gold/ld"
 	"go.etcd.io/bbolt"
 )
 
+// metaBucket is the top-level bucket that stores information about the data file itself
+const metaBucket = "_goauld"
+
+// formatVersionKey is the key in the metaBucket under which the on-disk format version is stored
+const formatVersionKey = "formatVersion"
+
+// formatVersion is the current on-disk format version.
+// Version 1 (or no version) uses unescaped compound index keys, version 2 uses the escaped keys created by ComposeKey.
+const formatVersion = 2
+
+// ErrUnsupportedFormat is returned when the data file has been written by a newer version
+var ErrUnsupportedFormat = errors.New("unsupported format version")
+
+// ErrReservedName is returned when a collection or index uses a name that is reserved for internal buckets
+var ErrReservedName = errors.New("reserved name")
+
+// internalBuckets are the buckets within a collection bucket that are not index buckets
+var internalBuckets = map[string]bool{
+	documentBucket:   true,
+	expandedBucket:   true,
+	nodeBucket:       true,
+	indexBuildBucket: true,
+}
+
+// isReservedCollectionName returns true for names that collide with the top-level buckets of the store itself
+func isReservedCollectionName(name string) bool {
+	return name == metaBucket
+}
+
 // Store is the main interface for storing/finding documents
 type Store interface {
 	// Collection creates or returns a collection.
 	// On the db level it's a bucket for the documents and 1 bucket per index.
-	Collection(name string) Collection
+	// The options are only applied when the collection is created.
+	// The name of the bucket that stores the format version ("_goauld") is reserved, writes to that collection return ErrReservedName.
+	Collection(name string, options ...CollectionOption) Collection
+	// Update executes the function within a single read-write transaction.
+	// All collection operations done through the WriteTx are committed together, or not at all when an error is returned.
+	Update(fn func(tx WriteTx) error) error
+	// View executes the function within a single read-only transaction.
+	View(fn func(tx ReadTx) error) error
+	// Join returns the pairs of documents from two collections that reference each other, see Join.
+	// returns ErrUnknownCollection when a collection hasn't been opened with Collection.
+	// returns context errors when the context has been cancelled or deadline has exceeded.
+	Join(ctx context.Context, join Join) ([]DocumentPair, error)
+	// TermPathBuilder returns a TermPathBuilder that expands terms and compact IRIs with the JSON-LD context.
+	// The context is given like the value of @context: an IRI, a context definition or an array of both.
+	// Remote contexts are loaded with the document loader of the store. It returns an error wrapping ErrInvalidContext.
+	TermPathBuilder(context interface{}) (*TermPathBuilder, error)
 	// Close closes the bbolt DB
 	Close() error
 }
@@ -44,6 +92,9 @@ type store struct {
 	documentProcessor *ld.JsonLdProcessor
 	// options is used during configuration
 	options bbolt.Options
+	// batchSize and batchDelay configure the bbolt batch used by BatchAdd and BatchDelete, zero means bbolt defaults.
+	batchSize  int
+	batchDelay time.Duration
 }
 
 type StoreOption func(store *store)
@@ -55,6 +106,15 @@ func WithoutSync() StoreOption {
 	}
 }
 
+// WithBatchOptions configures how concurrent BatchAdd and BatchDelete calls are combined.
+// maxSize is the maximum number of calls in a single transaction, maxDelay is the maximum time to wait for other calls.
+func WithBatchOptions(maxSize int, maxDelay time.Duration) StoreOption {
+	return func(store *store) {
+		store.batchSize = maxSize
+		store.batchDelay = maxDelay
+	}
+}
+
 // WithDocumentLoader overrides the default document loader
 func WithDocumentLoader(documentLoader ld.DocumentLoader) StoreOption {
 	return func(store *store) {
@@ -87,17 +147,92 @@ func NewStore(dbFile string, options ...StoreOption) (Store, error) {
 	if err != nil {
 		return nil, err
 	}
+	if st.batchSize > 0 {
+		st.db.MaxBatchSize = st.batchSize
+	}
+	if st.batchDelay > 0 {
+		st.db.MaxBatchDelay = st.batchDelay
+	}
+
+	if err = st.migrate(); err != nil {
+		_ = st.db.Close()
+		return nil, err
+	}
 
 	return st, nil
 }
 
-func (s *store) Collection(name string) Collection {
+// migrate checks the on-disk format version and upgrades older data files.
+// Indices of an older format are dropped, they are rebuilt from the documents when they're added to the collection again.
+func (s *store) migrate() error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+		if err != nil {
+			return err
+		}
+
+		version := 1
+		if v := meta.Get([]byte(formatVersionKey)); len(v) == 1 {
+			version = int(v[0])
+		}
+
+		switch {
+		case version > formatVersion:
+			return fmt.Errorf("%w: %d", ErrUnsupportedFormat, version)
+		case version < formatVersion:
+			if err := dropIndices(tx); err != nil {
+				return err
+			}
+		}
+
+		return meta.Put([]byte(formatVersionKey), []byte{formatVersion})
+	})
+}
+
+// dropIndices removes all index buckets and the markers of index builds from all collections.
+// The documents, expanded documents and nodes are kept.
+func dropIndices(tx *bbolt.Tx) error {
+	return tx.ForEach(func(name []byte, collectionBucket *bbolt.Bucket) error {
+		if isReservedCollectionName(string(name)) {
+			return nil
+		}
+
+		indexNames := make([][]byte, 0)
+		if err := collectionBucket.ForEach(func(k, v []byte) error {
+			// only sub-buckets have a nil value
+			if v == nil && !internalBuckets[string(k)] {
+				indexNames = append(indexNames, k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if collectionBucket.Bucket([]byte(indexBuildBucket)) != nil {
+			indexNames = append(indexNames, []byte(indexBuildBucket))
+		}
+
+		for _, indexName := range indexNames {
+			if err := collectionBucket.DeleteBucket(indexName); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *store) Collection(name string, options ...CollectionOption) Collection {
 	c, ok := s.collections[name]
 	if !ok {
 		c = &collection{
-			Name:    name,
-			db:      s.db,
-			refMake: defaultReferenceCreator,
+			Name:              name,
+			db:                s.db,
+			refMake:           defaultReferenceCreator,
+			refName:           SHA1ReferenceFuncName,
+			documentLoader:    s.documentLoader,
+			documentProcessor: s.documentProcessor,
+		}
+		for _, option := range options {
+			option(c)
 		}
 		s.collections[name] = c
 	}
@@ -105,6 +240,18 @@ func (s *store) Collection(name string) Collection {
 	return c
 }
 
+func (s *store) Update(fn func(tx WriteTx) error) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return fn(writeTx{storeTx{store: s, tx: tx}})
+	})
+}
+
+func (s *store) View(fn func(tx ReadTx) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return fn(readTx{storeTx{store: s, tx: tx}})
+	})
+}
+
 func (s *store) Close() error {
 	if s.db != nil {
 		return s.db.Close()
@@ -20,10 +20,14 @@
 package goauld
 
 import (
+	"context"
+	"errors"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
 )
 
 func TestNewStore(t *testing.T) {
@@ -38,6 +42,19 @@ func TestNewStore(t *testing.T) {
 		assert.NotNil(t, s)
 	})
 
+	t.Run("ok - batch options", func(t *testing.T) {
+		f := filepath.Join(testDirectory(t), "test.db")
+		s, err := NewStore(f, WithoutSync(), WithBatchOptions(10, time.Millisecond))
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		db := s.(*store).db
+		assert.Equal(t, 10, db.MaxBatchSize)
+		assert.Equal(t, time.Millisecond, db.MaxBatchDelay)
+	})
+
 	t.Run("error", func(t *testing.T) {
 		_, err := NewStore("store_test.go", WithoutSync())
 
@@ -67,6 +84,12 @@ func TestStore_Collection(t *testing.T) {
 		assert.NotNil(t, c.(*collection).Name)
 	})
 
+	t.Run("options are applied", func(t *testing.T) {
+		c := s.Collection("options", WithIdentityTermPath(NewTermPath("path")))
+
+		assert.Equal(t, NewTermPath("path"), c.(*collection).identityPath)
+	})
+
 	t.Run("collections are stored in instance", func(t *testing.T) {
 		c2 := s.Collection("test").(*collection)
 
@@ -75,4 +98,86 @@ func TestStore_Collection(t *testing.T) {
 
 		assert.Len(t, c2.IndexList, 1)
 	})
+
+	t.Run("error - reserved collection name", func(t *testing.T) {
+		reserved := s.Collection(metaBucket)
+
+		err := reserved.Add([]Document{jsonLdExample})
+
+		assert.True(t, errors.Is(err, ErrReservedName))
+		docs, err := reserved.Find(context.Background(), New(Eq(NewTermPath("http://schema.org/name"), ScalarMustParse("Jane Doe"))))
+		assert.NoError(t, err)
+		assert.Len(t, docs, 0)
+	})
+
+	t.Run("error - reserved index name", func(t *testing.T) {
+		err := c.AddIndex(c.NewIndex(documentBucket, NewFieldIndexer(NewTermPath("path"))))
+
+		assert.True(t, errors.Is(err, ErrReservedName))
+	})
+}
+
+func TestStore_migrate(t *testing.T) {
+	t.Run("ok - version is written to a new store", func(t *testing.T) {
+		s := testStore(t)
+
+		_ = s.db.View(func(tx *bbolt.Tx) error {
+			v := tx.Bucket([]byte(metaBucket)).Get([]byte(formatVersionKey))
+			assert.Equal(t, []byte{formatVersion}, v)
+			return nil
+		})
+	})
+
+	t.Run("ok - indices of older versions are dropped", func(t *testing.T) {
+		f := filepath.Join(testDirectory(t), "test.db")
+		db, _ := bbolt.Open(f, boltDBFileMode, &bbolt.Options{NoSync: true})
+		_ = db.Update(func(tx *bbolt.Tx) error {
+			b, _ := tx.CreateBucketIfNotExists([]byte("test"))
+			docs, _ := b.CreateBucketIfNotExists(documentBucketByteRef())
+			_ = docs.Put(defaultReferenceCreator(jsonLdExample), jsonLdExample)
+			_, _ = b.CreateBucketIfNotExists([]byte("index"))
+			_, _ = b.CreateBucketIfNotExists([]byte(expandedBucket))
+			_, _ = b.CreateBucketIfNotExists([]byte(nodeBucket))
+			markers, _ := b.CreateBucketIfNotExists([]byte(indexBuildBucket))
+			_ = markers.Put([]byte("index"), []byte{})
+			return nil
+		})
+		_ = db.Close()
+
+		s, err := NewStore(f, WithoutSync(), WithDocumentLoader(testDocumentLoader))
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer s.Close()
+
+		_ = s.(*store).db.View(func(tx *bbolt.Tx) error {
+			b := tx.Bucket([]byte("test"))
+			assert.Nil(t, b.Bucket([]byte("index")))
+			assert.Nil(t, b.Bucket([]byte(indexBuildBucket)))
+			assert.NotNil(t, b.Bucket(documentBucketByteRef()))
+			assert.NotNil(t, b.Bucket([]byte(expandedBucket)))
+			assert.NotNil(t, b.Bucket([]byte(nodeBucket)))
+			return nil
+		})
+
+		// re-adding the index rebuilds it
+		c := s.Collection("test")
+		i := c.NewIndex("index", NewFieldIndexer(NewTermPath("http://schema.org/name")))
+		_ = c.AddIndex(i)
+		assertIndexSize(t, s.(*store).db, i, 1)
+	})
+
+	t.Run("error - newer version", func(t *testing.T) {
+		f := filepath.Join(testDirectory(t), "test.db")
+		db, _ := bbolt.Open(f, boltDBFileMode, &bbolt.Options{NoSync: true})
+		_ = db.Update(func(tx *bbolt.Tx) error {
+			b, _ := tx.CreateBucketIfNotExists([]byte(metaBucket))
+			return b.Put([]byte(formatVersionKey), []byte{formatVersion + 1})
+		})
+		_ = db.Close()
+
+		_, err := NewStore(f, WithoutSync())
+
+		assert.True(t, errors.Is(err, ErrUnsupportedFormat))
+	})
 }
@@ -0,0 +1,147 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/piprate/json-gold/ld"
+)
+
+// ErrInvalidContext is returned when a JSON-LD context can't be processed
+var ErrInvalidContext = errors.New("invalid JSON-LD context")
+
+// ErrUnresolvedTerm is returned when a term can't be expanded to an IRI with the JSON-LD context
+var ErrUnresolvedTerm = errors.New("unresolved term")
+
+// TermPathBuilder creates TermPaths from JSON-LD terms and compact IRIs by expanding them with a JSON-LD context.
+// It's created by Store.TermPathBuilder and can be used concurrently.
+type TermPathBuilder struct {
+	context *ld.Context
+}
+
+func (s *store) TermPathBuilder(context interface{}) (*TermPathBuilder, error) {
+	options := ld.NewJsonLdOptions("")
+	options.DocumentLoader = s.documentLoader
+	activeContext, err := ld.NewContext(nil, options).Parse(context)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidContext, err)
+	}
+	return &TermPathBuilder{context: activeContext}, nil
+}
+
+// Build returns a TermPath with the terms expanded to IRIs.
+// A term is a term defined by the context, a compact IRI with a prefix defined by the context, an absolute IRI
+// or a term relative to the @vocab of the context. Aliases of @id and @type become IDTerm and TypeTerm,
+// terms defined as @reverse property become a ReverseTerm. The IRI of a term created by TypeFilterTerm, IDFilterTerm,
+// GraphTerm or ReverseTerm is expanded as well, the other special terms are kept as is.
+// A property-scoped context applies to the terms that follow the property. Type-scoped contexts are not applied.
+// It returns an error wrapping ErrUnresolvedTerm for a term that doesn't expand to an absolute IRI,
+// like an undefined term without @vocab or a compact IRI with an undefined prefix.
+func (b *TermPathBuilder) Build(terms ...string) (TermPath, error) {
+	if len(terms) == 0 {
+		return TermPath{}, fmt.Errorf("%w: no terms given", ErrUnresolvedTerm)
+	}
+
+	activeContext := b.context
+	result := make([]string, len(terms))
+	for j, term := range terms {
+		var definition map[string]interface{}
+		var err error
+		if result[j], definition, err = expandTerm(activeContext, term); err != nil {
+			return TermPath{}, err
+		}
+		if scoped, ok := definition["@context"]; ok {
+			if activeContext, err = activeContext.Parse(scoped); err != nil {
+				return TermPath{}, fmt.Errorf("%w: scoped context of %q: %s", ErrInvalidContext, term, err)
+			}
+		}
+	}
+	return NewTermPath(result...), nil
+}
+
+// Parse returns a TermPath for terms separated by dots, like "credentialSubject.name", see Build.
+// Use Build for terms that contain a dot, like most absolute IRIs.
+func (b *TermPathBuilder) Parse(path string) (TermPath, error) {
+	return b.Build(strings.Split(path, ".")...)
+}
+
+// expandTerm returns the expanded term and the term definition if the term is defined by the context
+func expandTerm(activeContext *ld.Context, term string) (string, map[string]interface{}, error) {
+	switch term {
+	case WildcardTerm, DescendantTerm, AllGraphsTerm, DereferenceTerm, IDTerm, TypeTerm:
+		return term, nil, nil
+	}
+
+	for _, special := range []struct {
+		fromTerm func(term string) (string, bool)
+		toTerm   func(iri string) string
+		// vocab is true when the IRI is relative to @vocab like a property or type, false for node IRIs
+		vocab bool
+	}{{typeFromFilterTerm, TypeFilterTerm, true}, {idFromFilterTerm, IDFilterTerm, false}, {graphFromTerm, GraphTerm, false}, {reverseFromTerm, ReverseTerm, true}} {
+		if value, ok := special.fromTerm(term); ok {
+			iri, err := expandIRI(activeContext, value, special.vocab)
+			if err == nil && ld.IsKeyword(iri) {
+				err = fmt.Errorf("%w: %q expands to keyword %s", ErrUnresolvedTerm, value, iri)
+			}
+			return special.toTerm(iri), nil, err
+		}
+	}
+
+	iri, err := expandIRI(activeContext, term, true)
+	if err != nil {
+		return "", nil, err
+	}
+	switch {
+	case iri == IDTerm || iri == TypeTerm:
+		return iri, nil, nil
+	case ld.IsKeyword(iri):
+		return "", nil, fmt.Errorf("%w: %q expands to keyword %s, which can't be used in a TermPath", ErrUnresolvedTerm, term, iri)
+	}
+
+	definition := activeContext.GetTermDefinition(term)
+	if reverse, _ := definition["@reverse"].(bool); reverse {
+		return ReverseTerm(iri), definition, nil
+	}
+	return iri, definition, nil
+}
+
+// expandIRI expands a term or compact IRI and checks the result is an absolute IRI or keyword
+func expandIRI(activeContext *ld.Context, value string, vocab bool) (string, error) {
+	iri, err := activeContext.ExpandIri(value, false, vocab, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q: %s", ErrUnresolvedTerm, value, err)
+	}
+	if ld.IsKeyword(iri) {
+		return iri, nil
+	}
+	if iri == "" || !ld.IsAbsoluteIri(iri) {
+		return "", fmt.Errorf("%w: %q", ErrUnresolvedTerm, value)
+	}
+	// a compact IRI with an undefined prefix is returned as is by ExpandIri
+	if separator := strings.IndexByte(value, ':'); iri == value && separator > 0 && !strings.HasPrefix(value[separator+1:], "//") {
+		if activeContext.GetTermDefinition(value[:separator]) == nil {
+			return "", fmt.Errorf("%w: %q: undefined prefix %q", ErrUnresolvedTerm, value, value[:separator])
+		}
+	}
+	return iri, nil
+}
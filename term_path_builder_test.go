@@ -0,0 +1,138 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var termPathContext = map[string]interface{}{
+	"ex":     "http://example.com/",
+	"id":     "@id",
+	"type":   "@type",
+	"graph":  "@graph",
+	"hidden": nil,
+	"parent": map[string]interface{}{"@reverse": "ex:child"},
+	"credentialSubject": map[string]interface{}{
+		"@id":      "ex:credentialSubject",
+		"@context": map[string]interface{}{"name": "ex:subjectName"},
+	},
+	"name": "ex:name",
+}
+
+func TestStore_TermPathBuilder(t *testing.T) {
+	t.Run("ok - remote context", func(t *testing.T) {
+		b, err := testStore(t).TermPathBuilder("http://schema.org/")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		termPath, err := b.Parse("children.name")
+
+		assert.NoError(t, err)
+		assert.Equal(t, NewTermPath("http://schema.org/children", "http://schema.org/name"), termPath)
+	})
+
+	t.Run("error - invalid context", func(t *testing.T) {
+		_, err := testStore(t).TermPathBuilder(map[string]interface{}{"@vocab": 1.0})
+
+		assert.True(t, errors.Is(err, ErrInvalidContext))
+	})
+}
+
+func TestTermPathBuilder_Build(t *testing.T) {
+	b, err := testStore(t).TermPathBuilder(termPathContext)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("ok - terms, compact IRIs and absolute IRIs", func(t *testing.T) {
+		termPath, err := b.Build("name", "ex:age", "http://schema.org/name")
+
+		assert.NoError(t, err)
+		assert.Equal(t, NewTermPath("http://example.com/name", "http://example.com/age", "http://schema.org/name"), termPath)
+	})
+
+	t.Run("ok - property-scoped context", func(t *testing.T) {
+		termPath, err := b.Parse("credentialSubject.name")
+
+		assert.NoError(t, err)
+		assert.Equal(t, NewTermPath("http://example.com/credentialSubject", "http://example.com/subjectName"), termPath)
+	})
+
+	t.Run("ok - keyword aliases and reverse properties", func(t *testing.T) {
+		termPath, err := b.Build("parent", "type")
+
+		assert.NoError(t, err)
+		assert.Equal(t, NewTermPath(ReverseTerm("http://example.com/child"), TypeTerm), termPath)
+
+		termPath, err = b.Parse("id")
+
+		assert.NoError(t, err)
+		assert.Equal(t, NewTermPath(IDTerm), termPath)
+	})
+
+	t.Run("ok - special terms", func(t *testing.T) {
+		termPath, err := b.Build(AllGraphsTerm, TypeFilterTerm("ex:Person"), IDFilterTerm("ex:jane"), GraphTerm("ex:graph"),
+			ReverseTerm("ex:knows"), WildcardTerm, DescendantTerm, DereferenceTerm, IDTerm)
+
+		assert.NoError(t, err)
+		assert.Equal(t, NewTermPath(AllGraphsTerm, TypeFilterTerm("http://example.com/Person"), IDFilterTerm("http://example.com/jane"),
+			GraphTerm("http://example.com/graph"), ReverseTerm("http://example.com/knows"), WildcardTerm, DescendantTerm, DereferenceTerm, IDTerm), termPath)
+	})
+
+	t.Run("ok - usable in a query", func(t *testing.T) {
+		s := testStore(t)
+		c := s.Collection("test")
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		schema, _ := s.TermPathBuilder([]interface{}{"http://schema.org/"})
+		name, _ := schema.Parse("name")
+
+		docs, err := c.Find(context.Background(), New(Eq(name, ScalarMustParse("Jane Doe"))))
+
+		assert.NoError(t, err)
+		assert.Len(t, docs, 1)
+	})
+
+	errorCases := []struct {
+		name    string
+		terms   []string
+		message string
+	}{
+		{"undefined term", []string{"name", "age"}, `unresolved term: "age"`},
+		{"undefined prefix", []string{"foo:name"}, `unresolved term: "foo:name": undefined prefix "foo"`},
+		{"term mapped to null", []string{"hidden"}, `unresolved term: "hidden"`},
+		{"unsupported keyword", []string{"graph"}, `unresolved term: "graph" expands to keyword @graph, which can't be used in a TermPath`},
+		{"undefined type", []string{TypeFilterTerm("Person")}, `unresolved term: "Person"`},
+		{"no terms", nil, "unresolved term: no terms given"},
+	}
+	for _, c := range errorCases {
+		t.Run("error - "+c.name, func(t *testing.T) {
+			_, err := b.Build(c.terms...)
+
+			assert.True(t, errors.Is(err, ErrUnresolvedTerm))
+			assert.EqualError(t, err, c.message)
+		})
+	}
+}
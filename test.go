@@ -29,6 +29,7 @@ import (
 	"regexp"
 	"testing"
 
+	"github.com/piprate/json-gold/ld"
 	"github.com/stretchr/testify/assert"
 	"go.etcd.io/bbolt"
 )
@@ -66,6 +67,97 @@ var jsonLdExample2 = []byte(`
 }
 `)
 
+var jsonLdTypedExample = []byte(`
+{
+  "@context": ["http://schema.org/"],
+  "@type": "Person",
+  "name": "Jane Doe",
+  "knows": [
+    {
+      "@type": "Person",
+      "name": "John Doe"
+    }
+  ],
+  "worksFor": {
+    "@type": "Organization",
+    "name": "Nuts"
+  }
+}
+`)
+
+var jsonLdMultiTypeExample = []byte(`
+{
+  "@context": ["http://schema.org/"],
+  "@type": ["Person", "Patient"],
+  "name": "Jack Doe"
+}
+`)
+
+var jsonLdReverseExample = []byte(`
+{
+  "@context": ["http://schema.org/", {
+    "employees": {"@reverse": "http://schema.org/worksFor"}
+  }],
+  "@id": "http://example.com/nuts",
+  "@type": "Organization",
+  "name": "Nuts",
+  "employees": [
+    {
+      "@id": "http://example.com/jane",
+      "name": "Jane Doe"
+    },
+    {
+      "@id": "http://example.com/john",
+      "name": "John Doe"
+    }
+  ]
+}
+`)
+
+var jsonLdGraphExample = []byte(`
+{
+  "@context": ["http://schema.org/"],
+  "@graph": [
+    {
+      "@id": "http://example.com/credential",
+      "@type": "Dataset",
+      "name": "Employees"
+    },
+    {
+      "@id": "http://example.com/employees",
+      "@graph": [
+        {
+          "@id": "http://example.com/jane",
+          "@type": "Person",
+          "name": "Jane Doe"
+        },
+        {
+          "@id": "http://example.com/john",
+          "@type": "Person",
+          "name": "John Doe"
+        }
+      ]
+    }
+  ]
+}
+`)
+
+// schemaOrgContext is a trimmed down version of the http://schema.org/ context, so tests can run without network access
+var schemaOrgContext = map[string]interface{}{
+	"@context": map[string]interface{}{
+		"@vocab": "http://schema.org/",
+		"schema": "http://schema.org/",
+		"url":    map[string]interface{}{"@id": "schema:url", "@type": "@id"},
+	},
+}
+
+// testDocumentLoader serves the contexts used in tests from memory
+var testDocumentLoader = func() ld.DocumentLoader {
+	loader := ld.NewCachingDocumentLoader(ld.NewDefaultDocumentLoader(nil))
+	loader.AddDocument("http://schema.org/", schemaOrgContext)
+	return loader
+}()
+
 var invalidPathCharRegex = regexp.MustCompile("([^a-zA-Z0-9])")
 
 // testDirectory returns a temporary directory for this test only. Calling TestDirectory multiple times for the same
@@ -102,7 +194,7 @@ func testDB(t *testing.T) *bbolt.DB {
 }
 
 func testStore(t *testing.T) *store {
-	s, err := NewStore(filepath.Join(testDirectory(t), "test.db"), WithoutSync())
+	s, err := NewStore(filepath.Join(testDirectory(t), "test.db"), WithoutSync(), WithDocumentLoader(testDocumentLoader))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -174,6 +266,27 @@ func assertIndexSize(t *testing.T, db *bbolt.DB, i Index, size int) bool {
 	return assert.NoError(t, err)
 }
 
+// assertIndexEmpty checks if an index contains no keys at all
+func assertIndexEmpty(t *testing.T, db *bbolt.DB, i Index) bool {
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := testBucket(t, tx)
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket(i.BucketName())
+		if b == nil {
+			return nil
+		}
+
+		if k, _ := b.Cursor().First(); k != nil {
+			return fmt.Errorf("index %s contains key %v", i.Name(), k)
+		}
+		return nil
+	})
+
+	return assert.NoError(t, err)
+}
+
 // assertSize checks a bucket size
 func assertSize(t *testing.T, db *bbolt.DB, bucketName string, size int) bool {
 	err := db.View(func(tx *bbolt.Tx) error {
@@ -0,0 +1,285 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrUnknownFormat is returned when an import or export format isn't supported
+var ErrUnknownFormat = errors.New("unknown format")
+
+// ErrNoObject is reported by Import for documents that are valid JSON but not a JSON object
+var ErrNoObject = errors.New("document is not a JSON object")
+
+// ErrInvalidNQuad is reported by Import for lines that are not a valid N-Quad
+var ErrInvalidNQuad = errors.New("invalid N-Quad")
+
+// Format is the serialization format used by Import and Export
+type Format string
+
+const (
+	// FormatNDJSON is newline-delimited JSON: one document per line.
+	// Empty lines are ignored. Exported documents are compacted to a single line.
+	FormatNDJSON Format = "ndjson"
+	// FormatJSONArray is a JSON array with a document per element.
+	FormatJSONArray Format = "json"
+	// FormatNQuads is the RDF N-Quads format, N-Triples can be imported as well since it's a subset.
+	// Imported datasets are read completely before the nodes are converted to JSON-LD documents.
+	// Exported documents are converted to RDF using the document loader of the store.
+	FormatNQuads Format = "nquads"
+)
+
+// defaultImportBatchSize is the number of documents committed per transaction by Import
+const defaultImportBatchSize = 1000
+
+// ImportReport is the result of an Import
+type ImportReport struct {
+	// Imported is the number of documents that have been added
+	Imported int
+	// Errors contains the documents that have been skipped
+	Errors []LineError
+}
+
+// LineError is the error for a single document in the input of an Import
+type LineError struct {
+	// Line is the line number for FormatNDJSON and the position of the element for FormatJSONArray, starting at 1.
+	// For FormatNQuads it's the line where the node is first used as subject or graph name.
+	Line int
+	// Err is the cause
+	Err error
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+}
+
+// Unwrap returns the cause
+func (e LineError) Unwrap() error {
+	return e.Err
+}
+
+// importLine is a document read from the input with its position
+type importLine struct {
+	line int
+	doc  Document
+}
+
+func (c *collection) Import(ctx context.Context, reader io.Reader, format Format) (ImportReport, error) {
+	report := ImportReport{Errors: []LineError{}}
+
+	batchSize := c.importBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+
+	batch := make([]importLine, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.importBatch(batch, &report); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
+	emit := func(line importLine) error {
+		if line.doc[0] != '{' {
+			report.Errors = append(report.Errors, LineError{Line: line.line, Err: ErrNoObject})
+			return nil
+		}
+		batch = append(batch, line)
+		if len(batch) == batchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	var err error
+	switch format {
+	case FormatNDJSON:
+		err = readNDJSON(reader, emit, &report)
+	case FormatJSONArray:
+		err = readJSONArray(reader, emit)
+	case FormatNQuads:
+		err = c.readNQuads(reader, emit, &report)
+	default:
+		return report, ErrUnknownFormat
+	}
+	if err != nil {
+		return report, err
+	}
+
+	return report, flush()
+}
+
+// importBatch adds the documents of a batch that could be processed within a single transaction
+func (c *collection) importBatch(batch []importLine, report *ImportReport) error {
+	docs := make([]Document, len(batch))
+	for j, line := range batch {
+		docs[j] = line.doc
+	}
+
+	prepared, errs := c.prepareEach(docs)
+	valid := make([]preparedDocument, 0, len(prepared))
+	var failed []LineError
+	for j, err := range errs {
+		if err != nil {
+			failed = append(failed, LineError{Line: batch[j].line, Err: err})
+			continue
+		}
+		valid = append(valid, prepared[j])
+	}
+
+	if len(valid) > 0 {
+		if err := c.db.Update(func(tx *bbolt.Tx) error {
+			return c.addPrepared(tx, valid)
+		}); err != nil {
+			return err
+		}
+	}
+
+	report.Imported += len(valid)
+	report.Errors = append(report.Errors, failed...)
+	return nil
+}
+
+// readNDJSON calls emit for every non-empty line, lines that are not valid JSON are added to the report
+func readNDJSON(reader io.Reader, emit func(importLine) error, report *ImportReport) error {
+	bufReader := bufio.NewReader(reader)
+	line := 0
+	for {
+		raw, readErr := bufReader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		line++
+
+		if doc := bytes.TrimSpace(raw); len(doc) > 0 {
+			if !json.Valid(doc) {
+				report.Errors = append(report.Errors, LineError{Line: line, Err: ErrInvalidJSON})
+			} else if err := emit(importLine{line: line, doc: doc}); err != nil {
+				return err
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// readJSONArray calls emit for every element of the array.
+// Syntax errors can't be recovered from, so they stop the import.
+func readJSONArray(reader io.Reader, emit func(importLine) error) error {
+	decoder := json.NewDecoder(reader)
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("%w: expected JSON array", ErrInvalidJSON)
+	}
+
+	line := 0
+	for decoder.More() {
+		line++
+		var doc json.RawMessage
+		if err = decoder.Decode(&doc); err != nil {
+			return LineError{Line: line, Err: err}
+		}
+		if err = emit(importLine{line: line, doc: Document(doc)}); err != nil {
+			return err
+		}
+	}
+
+	// closing bracket
+	_, err = decoder.Token()
+	return err
+}
+
+func (c *collection) Export(ctx context.Context, writer io.Writer, query Query, format Format) error {
+	if format != FormatNDJSON && format != FormatJSONArray && format != FormatNQuads {
+		return ErrUnknownFormat
+	}
+
+	bufWriter := bufio.NewWriter(writer)
+	count := 0
+	compacted := bytes.Buffer{}
+	walker := func(ref Reference, doc []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var err error
+		switch format {
+		case FormatNDJSON:
+			compacted.Reset()
+			if err = json.Compact(&compacted, doc); err == nil {
+				compacted.WriteByte('\n')
+				_, err = bufWriter.Write(compacted.Bytes())
+			}
+		case FormatJSONArray:
+			if count > 0 {
+				_, err = bufWriter.WriteString(",\n")
+			}
+			if err == nil {
+				_, err = bufWriter.Write(doc)
+			}
+		case FormatNQuads:
+			err = c.writeNQuads(bufWriter, doc, count)
+		}
+		count++
+		return err
+	}
+
+	if format == FormatJSONArray {
+		if _, err := bufWriter.WriteString("["); err != nil {
+			return err
+		}
+	}
+
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		return c.iterateOrScan(tx, query, walker)
+	})
+	if err != nil {
+		return err
+	}
+
+	if format == FormatJSONArray {
+		if _, err = bufWriter.WriteString("]\n"); err != nil {
+			return err
+		}
+	}
+
+	return bufWriter.Flush()
+}
@@ -0,0 +1,210 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollection_Import(t *testing.T) {
+	personQuery := New(IsType("http://schema.org/Person"))
+	identifierQuery := New(Eq(NewTermPath("http://schema.org/identifier"), ScalarMustParse("2")))
+
+	t.Run("ok - NDJSON in batches", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		c.importBatchSize = 2
+		input := strings.Builder{}
+		for j := 0; j < 5; j++ {
+			input.WriteString(fmt.Sprintf(`{"@context": ["http://schema.org/"], "@type": "Person", "identifier": "%d"}`, j))
+			input.WriteString("\n")
+		}
+
+		report, err := c.Import(context.Background(), strings.NewReader(input.String()), FormatNDJSON)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 5, report.Imported)
+		assert.Len(t, report.Errors, 0)
+		docs, _ := c.Find(context.Background(), identifierQuery)
+		assert.Len(t, docs, 1)
+	})
+
+	t.Run("ok - NDJSON with invalid lines", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		input := `{"@context": ["http://schema.org/"], "@type": "Person", "identifier": "1"}
+
+{"@context": ["http://schema.org/"], "@type": "Person",
+"not a document"
+{"@context": ["http://schema.org/"], "@type": "Person", "identifier": "2"}`
+
+		report, err := c.Import(context.Background(), strings.NewReader(input), FormatNDJSON)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 2, report.Imported)
+		if assert.Len(t, report.Errors, 2) {
+			assert.Equal(t, 3, report.Errors[0].Line)
+			assert.True(t, errors.Is(report.Errors[0], ErrInvalidJSON))
+			assert.Equal(t, 4, report.Errors[1].Line)
+			assert.Equal(t, "line 4: document is not a JSON object", report.Errors[1].Error())
+		}
+	})
+
+	t.Run("ok - JSON array", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		// JSON-LD processing errors surface when the documents are indexed
+		_ = c.AddIndex(c.NewIndex("type", NewFieldIndexer(NewTermPath(TypeTerm))))
+		input := fmt.Sprintf(`[%s, %s, 1, {"@context": 1}]`, jsonLdExample, jsonLdExample2)
+
+		report, err := c.Import(context.Background(), strings.NewReader(input), FormatJSONArray)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 2, report.Imported)
+		if assert.Len(t, report.Errors, 2) {
+			assert.Equal(t, 3, report.Errors[0].Line)
+			assert.True(t, errors.Is(report.Errors[0], ErrNoObject))
+			assert.Equal(t, 4, report.Errors[1].Line)
+		}
+		docs, _ := c.Find(context.Background(), personQuery)
+		assert.Len(t, docs, 2)
+	})
+
+	t.Run("error - malformed JSON array", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		c.importBatchSize = 1
+		input := fmt.Sprintf("[%s, {]", jsonLdExample)
+
+		report, err := c.Import(context.Background(), strings.NewReader(input), FormatJSONArray)
+
+		assert.Error(t, err)
+		assert.Equal(t, 1, report.Imported)
+	})
+
+	t.Run("error - not an array", func(t *testing.T) {
+		c := createCollection(testDB(t))
+
+		_, err := c.Import(context.Background(), bytes.NewReader(jsonLdExample), FormatJSONArray)
+
+		assert.True(t, errors.Is(err, ErrInvalidJSON))
+	})
+
+	t.Run("error - cancelled", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		report, err := c.Import(ctx, strings.NewReader(fmt.Sprintf("[%s]", jsonLdExample)), FormatJSONArray)
+
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 0, report.Imported)
+		docs, _ := c.Find(context.Background(), personQuery)
+		assert.Len(t, docs, 0)
+	})
+
+	t.Run("error - unknown format", func(t *testing.T) {
+		c := createCollection(testDB(t))
+
+		_, err := c.Import(context.Background(), strings.NewReader(""), "xml")
+
+		assert.Equal(t, ErrUnknownFormat, err)
+	})
+}
+
+func TestCollection_Export(t *testing.T) {
+	nameQuery := New(Eq(NewTermPath("http://schema.org/name"), ScalarMustParse("Jane Doe")))
+
+	t.Run("ok - NDJSON round trip", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		buffer := bytes.Buffer{}
+
+		err := c.Export(context.Background(), &buffer, nil, FormatNDJSON)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 2, strings.Count(buffer.String(), "\n"))
+		target := createCollection(testDB(t))
+		report, err := target.Import(context.Background(), &buffer, FormatNDJSON)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Imported)
+		docs, _ := target.Find(context.Background(), nameQuery)
+		assert.Len(t, docs, 1)
+	})
+
+	t.Run("ok - JSON array with query", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		buffer := bytes.Buffer{}
+
+		err := c.Export(context.Background(), &buffer, nameQuery, FormatJSONArray)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		target := createCollection(testDB(t))
+		report, err := target.Import(context.Background(), &buffer, FormatJSONArray)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Imported)
+		docs, _ := target.Find(context.Background(), nameQuery)
+		assert.Len(t, docs, 1)
+	})
+
+	t.Run("ok - empty collection", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		buffer := bytes.Buffer{}
+
+		err := c.Export(context.Background(), &buffer, nil, FormatJSONArray)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "[]\n", buffer.String())
+	})
+
+	t.Run("error - cancelled", func(t *testing.T) {
+		c := createCollection(testDB(t))
+		_ = c.Add([]Document{jsonLdExample})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := c.Export(ctx, &bytes.Buffer{}, nil, FormatNDJSON)
+
+		assert.Equal(t, context.Canceled, err)
+	})
+
+	t.Run("error - unknown format", func(t *testing.T) {
+		c := createCollection(testDB(t))
+
+		err := c.Export(context.Background(), &bytes.Buffer{}, nil, "xml")
+
+		assert.Equal(t, ErrUnknownFormat, err)
+	})
+}
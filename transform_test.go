@@ -87,7 +87,7 @@ func TestIndex_Add(t *testing.T) {
 		t.Run("ok - single word", func(t *testing.T) {
 			i := c.NewIndex("test", testIndexPart{termPath: NewTermPath("http://schema.org/url"), tokenizer: WhiteSpaceTokenizer, transformer: ToLower})
 			ref := []byte("01")
-			key := []byte("http://www.janedoe.com")
+			key := ComposeKey(nil, Key("http://www.janedoe.com"))
 
 			err := withinBucket(t, db, func(bucket *bbolt.Bucket) error {
 				return i.Add(bucket, ref, jsonLdExample)
@@ -101,8 +101,8 @@ func TestIndex_Add(t *testing.T) {
 		t.Run("ok - sentence", func(t *testing.T) {
 			i := c.NewIndex("test", testIndexPart{termPath: NewTermPath("http://schema.org/name"), tokenizer: WhiteSpaceTokenizer, transformer: ToLower})
 			ref := []byte("01")
-			key1 := []byte("jane")
-			key2 := []byte("doe")
+			key1 := ComposeKey(nil, Key("jane"))
+			key2 := ComposeKey(nil, Key("doe"))
 
 			err := withinBucket(t, db, func(bucket *bbolt.Bucket) error {
 				return i.Add(bucket, ref, jsonLdExample)
@@ -0,0 +1,145 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+
+	"go.etcd.io/bbolt"
+)
+
+// ReadTx gives access to collections within a read-only transaction.
+type ReadTx interface {
+	// Collection returns the collection bound to the transaction, it's created when it doesn't exist.
+	Collection(name string) ReadCollection
+}
+
+// WriteTx gives access to collections within a read-write transaction.
+type WriteTx interface {
+	// Collection returns the collection bound to the transaction, it's created when it doesn't exist.
+	Collection(name string) WriteCollection
+}
+
+// ReadCollection defines the read operations on a collection within a transaction.
+// The operations behave like the Collection operations with the same name.
+type ReadCollection interface {
+	// Get returns the data for the given key or nil if not found
+	Get(ref Reference, options ...OutputOption) (Document, error)
+	// Find queries the collection for documents
+	Find(ctx context.Context, query Query, options ...OutputOption) ([]Document, error)
+	// Iterate over documents that match the given query
+	Iterate(query Query, walker DocumentWalker) error
+	// Select executes a SPARQL query, parsed with ParseSPARQL
+	Select(ctx context.Context, query *SPARQLQuery) ([]Solution, error)
+}
+
+// WriteCollection defines the read and write operations on a collection within a transaction.
+// Reads see the writes done earlier in the same transaction.
+type WriteCollection interface {
+	ReadCollection
+	// Add a set of documents to this collection
+	Add(jsonSet []Document) error
+	// Upsert adds the document or replaces the document with the same identity.
+	Upsert(doc Document) (bool, error)
+	// Delete a document
+	Delete(doc Document) error
+	// DeleteByReference deletes the document stored under the given reference.
+	DeleteByReference(ref Reference) error
+	// DeleteWhere deletes all documents that match the query.
+	DeleteWhere(ctx context.Context, query Query) (int, error)
+}
+
+type storeTx struct {
+	store *store
+	tx    *bbolt.Tx
+}
+
+type readTx struct {
+	storeTx
+}
+
+type writeTx struct {
+	storeTx
+}
+
+func (t storeTx) collection(name string) txCollection {
+	return txCollection{
+		collection: t.store.Collection(name).(*collection),
+		tx:         t.tx,
+	}
+}
+
+func (t readTx) Collection(name string) ReadCollection {
+	return t.collection(name)
+}
+
+func (t writeTx) Collection(name string) WriteCollection {
+	return t.collection(name)
+}
+
+// txCollection binds a collection to a transaction
+type txCollection struct {
+	collection *collection
+	tx         *bbolt.Tx
+}
+
+func (t txCollection) Get(ref Reference, options ...OutputOption) (Document, error) {
+	docs, err := t.collection.output([]Document{t.collection.get(t.tx, ref)}, options)
+	if err != nil {
+		return nil, err
+	}
+	return docs[0], nil
+}
+
+func (t txCollection) Find(ctx context.Context, query Query, options ...OutputOption) ([]Document, error) {
+	docs, err := t.collection.find(ctx, t.tx, query)
+	if err != nil {
+		return nil, err
+	}
+	return t.collection.output(docs, options)
+}
+
+func (t txCollection) Iterate(query Query, walker DocumentWalker) error {
+	return t.collection.iterate(t.tx, query, walker)
+}
+
+func (t txCollection) Select(ctx context.Context, query *SPARQLQuery) ([]Solution, error) {
+	return t.collection.selectTx(ctx, t.tx, query)
+}
+
+func (t txCollection) Add(jsonSet []Document) error {
+	return t.collection.add(t.tx, jsonSet)
+}
+
+func (t txCollection) Upsert(doc Document) (bool, error) {
+	return t.collection.upsert(t.tx, doc)
+}
+
+func (t txCollection) Delete(doc Document) error {
+	return t.collection.delete(t.tx, doc)
+}
+
+func (t txCollection) DeleteByReference(ref Reference) error {
+	return t.collection.deleteByReference(t.tx, ref)
+}
+
+func (t txCollection) DeleteWhere(ctx context.Context, query Query) (int, error) {
+	return t.collection.deleteWhere(ctx, t.tx, query)
+}
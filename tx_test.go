@@ -0,0 +1,158 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_Update(t *testing.T) {
+	ref := defaultReferenceCreator(jsonLdExample)
+	nameTermPath := NewTermPath("http://schema.org/name")
+
+	t.Run("ok - move a document between collections", func(t *testing.T) {
+		s := testStore(t)
+		_ = s.Collection("pending").Add([]Document{jsonLdExample})
+
+		err := s.Update(func(tx WriteTx) error {
+			pending := tx.Collection("pending")
+			doc, err := pending.Get(ref)
+			if err != nil {
+				return err
+			}
+			if err = pending.DeleteByReference(ref); err != nil {
+				return err
+			}
+			return tx.Collection("accepted").Add([]Document{doc})
+		})
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		doc, _ := s.Collection("pending").Get(ref)
+		assert.Nil(t, doc)
+		doc, _ = s.Collection("accepted").Get(ref)
+		assert.Equal(t, Document(jsonLdExample), doc)
+	})
+
+	t.Run("ok - reads see uncommitted writes", func(t *testing.T) {
+		s := testStore(t)
+		c := s.Collection("test")
+		_ = c.AddIndex(c.NewIndex("name", NewFieldIndexer(nameTermPath)))
+
+		_ = s.Update(func(tx WriteTx) error {
+			tc := tx.Collection("test")
+			_ = tc.Add([]Document{jsonLdExample, jsonLdExample2})
+
+			doc, _ := tc.Get(ref)
+			assert.Equal(t, Document(jsonLdExample), doc)
+			docs, _ := tc.Find(context.Background(), New(Eq(nameTermPath, ScalarMustParse("Jane Doe"))))
+			assert.Len(t, docs, 1)
+
+			count, _ := tc.DeleteWhere(context.Background(), New(IsType("http://schema.org/Person")))
+			assert.Equal(t, 2, count)
+			docs, _ = tc.Find(context.Background(), New(Eq(nameTermPath, ScalarMustParse("Jane Doe"))))
+			assert.Len(t, docs, 0)
+			return nil
+		})
+	})
+
+	t.Run("ok - upsert and delete", func(t *testing.T) {
+		s := testStore(t)
+		v1 := []byte(`{"@context": ["http://schema.org/"], "@id": "http://example.com/jane", "name": "Jane Doe"}`)
+
+		err := s.Update(func(tx WriteTx) error {
+			tc := tx.Collection("test")
+			if _, err := tc.Upsert(v1); err != nil {
+				return err
+			}
+			replaced, err := tc.Upsert(v1)
+			assert.True(t, replaced)
+			if err != nil {
+				return err
+			}
+			return tc.Delete(v1)
+		})
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		docs, _ := s.Collection("test").Find(context.Background(), New(IsType("http://schema.org/Person")))
+		assert.Len(t, docs, 0)
+	})
+
+	t.Run("error - rolled back", func(t *testing.T) {
+		s := testStore(t)
+		_ = s.Collection("pending").Add([]Document{jsonLdExample})
+		expected := errors.New("b00m!")
+
+		err := s.Update(func(tx WriteTx) error {
+			_ = tx.Collection("pending").DeleteByReference(ref)
+			_ = tx.Collection("accepted").Add([]Document{jsonLdExample})
+			return expected
+		})
+
+		assert.Equal(t, expected, err)
+		doc, _ := s.Collection("pending").Get(ref)
+		assert.NotNil(t, doc)
+		doc, _ = s.Collection("accepted").Get(ref)
+		assert.Nil(t, doc)
+	})
+}
+
+func TestStore_View(t *testing.T) {
+	s := testStore(t)
+	_ = s.Collection("test").Add([]Document{jsonLdExample})
+
+	t.Run("ok", func(t *testing.T) {
+		err := s.View(func(tx ReadTx) error {
+			tc := tx.Collection("test")
+			doc, err := tc.Get(defaultReferenceCreator(jsonLdExample))
+			if err != nil {
+				return err
+			}
+			assert.NotNil(t, doc)
+
+			count := 0
+			err = tc.Iterate(New(IsType("http://schema.org/Person")), func(key Reference, value []byte) error {
+				count++
+				return nil
+			})
+			assert.Equal(t, 1, count)
+			return err
+		})
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("ok - unknown collection", func(t *testing.T) {
+		err := s.View(func(tx ReadTx) error {
+			docs, err := tx.Collection("unknown").Find(context.Background(), New(IsType("http://schema.org/Person")))
+			assert.Len(t, docs, 0)
+			return err
+		})
+
+		assert.NoError(t, err)
+	})
+}
@@ -22,11 +22,15 @@ package goauld
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"math"
+	"strings"
 )
 
 const boltDBFileMode = 0600
+
+// KeyDelimiter is used to escape key parts and to terminate them, see ComposeKey
 const KeyDelimiter = 0x10
 
 // Reference equals a document hash. In an index, the values are references to docs.
@@ -48,7 +52,105 @@ func (r Reference) ByteSize() int {
 	return len(r)
 }
 
-// TermPath represents a nested term structure (or graph path) using the fully qualified IRIs
+// WildcardTerm can be used as term in a TermPath, it matches any single term.
+const WildcardTerm = "*"
+
+// DescendantTerm can be used as term in a TermPath, it matches zero or more nested terms.
+const DescendantTerm = "**"
+
+// IDTerm can be used as last term in a TermPath to select the @id of a node.
+const IDTerm = "@id"
+
+// TypeTerm can be used as last term in a TermPath to select the @type IRIs of a node.
+const TypeTerm = "@type"
+
+// typeFilterPrefix is the prefix for terms that filter nodes on their @type.
+// JSON-LD reserves the @ prefix for keywords, so it can't collide with an IRI.
+const typeFilterPrefix = "@type="
+
+// TypeFilterTerm returns a term that only matches nodes that have the given IRI as @type.
+// It doesn't descend into the node, the next term in the TermPath is applied to the same node.
+func TypeFilterTerm(typeIRI string) string {
+	return typeFilterPrefix + typeIRI
+}
+
+// typeFromFilterTerm returns the type IRI if the term was created by TypeFilterTerm.
+func typeFromFilterTerm(term string) (string, bool) {
+	if !strings.HasPrefix(term, typeFilterPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(term, typeFilterPrefix), true
+}
+
+// AllGraphsTerm can be used as first term in a TermPath to evaluate the TermPath against the nodes of the named graphs within a document as well.
+// Without it, only the top-level nodes of a document are evaluated.
+const AllGraphsTerm = "@graphs"
+
+// idFilterPrefix is the prefix for terms that filter nodes on their @id.
+const idFilterPrefix = "@id="
+
+// IDFilterTerm returns a term that only matches the node with the given @id.
+// Like TypeFilterTerm, the next term in the TermPath is applied to the same node.
+func IDFilterTerm(id string) string {
+	return idFilterPrefix + id
+}
+
+// idFromFilterTerm returns the @id if the term was created by IDFilterTerm.
+func idFromFilterTerm(term string) (string, bool) {
+	if !strings.HasPrefix(term, idFilterPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(term, idFilterPrefix), true
+}
+
+// graphPrefix is the prefix for terms that select a named graph.
+const graphPrefix = "@graph="
+
+// GraphTerm returns a term that matches the named graph with the given IRI as @id.
+// The next term in the TermPath is applied to every node in the graph.
+// As last term, it selects the IRI of the graph, which can be used to find documents that contain the named graph.
+func GraphTerm(graphIRI string) string {
+	return graphPrefix + graphIRI
+}
+
+// graphFromTerm returns the graph IRI if the term was created by GraphTerm.
+func graphFromTerm(term string) (string, bool) {
+	if !strings.HasPrefix(term, graphPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(term, graphPrefix), true
+}
+
+// DereferenceTerm follows the IRIs selected by the preceding terms into the stored documents of the collection.
+// The next term is applied to the node with that @id in every document that describes it, see WithNodeIndex.
+// Values that aren't IRIs are skipped. A TermPath with the DereferenceTerm can't be indexed.
+const DereferenceTerm = "@dereference"
+
+// reversePrefix is the prefix for terms that follow a reverse property.
+const reversePrefix = "@reverse="
+
+// ReverseTerm returns a term that follows the given property in reverse direction.
+// It selects the nodes in the @reverse block of the expanded node that point to the node using the property IRI.
+func ReverseTerm(propertyIRI string) string {
+	return reversePrefix + propertyIRI
+}
+
+// reverseFromTerm returns the property IRI if the term was created by ReverseTerm.
+func reverseFromTerm(term string) (string, bool) {
+	if !strings.HasPrefix(term, reversePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(term, reversePrefix), true
+}
+
+// TermPath represents a nested term structure (or graph path) using the fully qualified IRIs.
+// Besides IRIs, a TermPath may contain the WildcardTerm, DescendantTerm, IDTerm, TypeTerm, AllGraphsTerm, DereferenceTerm
+// and terms created by TypeFilterTerm, IDFilterTerm, GraphTerm and ReverseTerm.
+// An index on such a TermPath indexes all matching values.
+//
+// A TermPath is evaluated against the top-level nodes of a document, nodes of a @graph without @id are top-level nodes after expansion.
+// Use IDFilterTerm to select a top-level node by its @id. The nodes of a named graph are evaluated after a GraphTerm,
+// or for all named graphs after the AllGraphsTerm.
 type TermPath struct {
 	// Terms represent the nested structure from highest (index 0) to lowest nesting
 	Terms []string
@@ -93,6 +195,19 @@ func (tp TermPath) Equals(other TermPath) bool {
 	return true
 }
 
+// MarshalJSON marshals the TermPath as an array of terms
+func (tp TermPath) MarshalJSON() ([]byte, error) {
+	if tp.Terms == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(tp.Terms)
+}
+
+// UnmarshalJSON unmarshals an array of terms
+func (tp *TermPath) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &tp.Terms)
+}
+
 // Scalar represents a JSON-LD scalar (string, number, true or false)
 type Scalar struct {
 	value interface{}
@@ -124,6 +239,28 @@ func ScalarMustParse(value interface{}) Scalar {
 	return s
 }
 
+// MarshalJSON marshals the Scalar as a JSON string, number or boolean
+func (s Scalar) MarshalJSON() ([]byte, error) {
+	if s.value == nil {
+		return nil, ErrInvalidValue
+	}
+	return json.Marshal(s.value)
+}
+
+// UnmarshalJSON unmarshals a JSON string, number or boolean. It returns ErrInvalidValue for other JSON values.
+func (s *Scalar) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := ScalarParse(value)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
 func (s Scalar) Bytes() []byte {
 	switch castData := s.value.(type) {
 	case bool:
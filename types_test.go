@@ -20,6 +20,8 @@
 package goauld
 
 import (
+	"encoding/json"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -37,3 +39,93 @@ func TestReference_ByteSize(t *testing.T) {
 
 	assert.Equal(t, 3, ref.ByteSize())
 }
+
+func TestTypeFilterTerm(t *testing.T) {
+	term := TypeFilterTerm("http://schema.org/Person")
+
+	typeIRI, ok := typeFromFilterTerm(term)
+
+	assert.True(t, ok)
+	assert.Equal(t, "http://schema.org/Person", typeIRI)
+
+	_, ok = typeFromFilterTerm("http://schema.org/name")
+
+	assert.False(t, ok)
+}
+
+func TestIDFilterTerm(t *testing.T) {
+	term := IDFilterTerm("http://example.com/jane")
+
+	id, ok := idFromFilterTerm(term)
+
+	assert.True(t, ok)
+	assert.Equal(t, "http://example.com/jane", id)
+
+	_, ok = idFromFilterTerm(TypeFilterTerm("http://schema.org/Person"))
+
+	assert.False(t, ok)
+}
+
+func TestGraphTerm(t *testing.T) {
+	term := GraphTerm("http://example.com/graph")
+
+	graphIRI, ok := graphFromTerm(term)
+
+	assert.True(t, ok)
+	assert.Equal(t, "http://example.com/graph", graphIRI)
+
+	_, ok = graphFromTerm(IDTerm)
+
+	assert.False(t, ok)
+}
+
+func TestTermPath_JSON(t *testing.T) {
+	t.Run("ok - array of terms", func(t *testing.T) {
+		data, err := json.Marshal(NewTermPath(TypeTerm, "http://schema.org/name"))
+
+		assert.NoError(t, err)
+		assert.Equal(t, `["@type","http://schema.org/name"]`, string(data))
+
+		var termPath TermPath
+		err = json.Unmarshal(data, &termPath)
+
+		assert.NoError(t, err)
+		assert.True(t, termPath.Equals(NewTermPath(TypeTerm, "http://schema.org/name")))
+	})
+
+	t.Run("ok - empty", func(t *testing.T) {
+		data, _ := json.Marshal(TermPath{})
+
+		assert.Equal(t, `[]`, string(data))
+	})
+}
+
+func TestScalar_JSON(t *testing.T) {
+	t.Run("ok - datatypes", func(t *testing.T) {
+		for _, value := range []interface{}{"Jane", 80.5, true} {
+			data, err := json.Marshal(ScalarMustParse(value))
+			if !assert.NoError(t, err) {
+				continue
+			}
+
+			var scalar Scalar
+			err = json.Unmarshal(data, &scalar)
+
+			assert.NoError(t, err)
+			assert.Equal(t, ScalarMustParse(value), scalar)
+		}
+	})
+
+	t.Run("error - unsupported value", func(t *testing.T) {
+		var scalar Scalar
+		err := json.Unmarshal([]byte(`{"@value": 1}`), &scalar)
+
+		assert.Equal(t, ErrInvalidValue, err)
+	})
+
+	t.Run("error - empty scalar", func(t *testing.T) {
+		_, err := json.Marshal(Scalar{})
+
+		assert.True(t, errors.Is(err, ErrInvalidValue))
+	})
+}
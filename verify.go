@@ -0,0 +1,274 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+
+	"go.etcd.io/bbolt"
+)
+
+// IndexEntry is a single reference stored under a key of an index
+type IndexEntry struct {
+	// Index is the name of the index
+	Index string
+	// Key is the (compound) index key
+	Key Key
+	// Reference is the document reference, it's nil for orphaned keys
+	Reference Reference
+}
+
+// VerifyReport lists the inconsistencies between the indices and the documents of a collection
+type VerifyReport struct {
+	// DanglingReferences are index entries for documents that do not exist or that shouldn't be indexed under the key
+	DanglingReferences []IndexEntry
+	// MissingEntries are index entries that should exist for a stored document but do not
+	MissingEntries []IndexEntry
+	// OrphanedKeys are index keys that do not contain any references
+	OrphanedKeys []IndexEntry
+}
+
+// IsConsistent returns true if no inconsistencies have been found
+func (r VerifyReport) IsConsistent() bool {
+	return len(r.DanglingReferences) == 0 && len(r.MissingEntries) == 0 && len(r.OrphanedKeys) == 0
+}
+
+func (c *collection) Verify(ctx context.Context) (VerifyReport, error) {
+	var report VerifyReport
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		report, err = c.verify(ctx, tx)
+		return err
+	})
+	return report, err
+}
+
+func (c *collection) Repair(ctx context.Context) (VerifyReport, error) {
+	var report VerifyReport
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		if report, err = c.verify(ctx, tx); err != nil {
+			return err
+		}
+		return c.repair(tx, report)
+	})
+	return report, err
+}
+
+// verify compares the entries of every index with the keys derived from the stored documents.
+// Every document is expanded once and its keys are checked against all indices, only the number of keys per document is kept.
+// The indices are walked afterwards, the keys of a document are only derived again when it has more entries than expected.
+func (c *collection) verify(ctx context.Context, tx *bbolt.Tx) (VerifyReport, error) {
+	report := VerifyReport{
+		DanglingReferences: []IndexEntry{},
+		MissingEntries:     []IndexEntry{},
+		OrphanedKeys:       []IndexEntry{},
+	}
+
+	bucket := c.bucket(tx)
+	if bucket == nil {
+		return report, nil
+	}
+	// the entries of indices that don't return the keys of a document can't be checked
+	indices := make([]Index, 0)
+	for _, i := range c.indices() {
+		if hasDocumentKeys(i) {
+			indices = append(indices, i)
+		}
+	}
+	expand := c.expander(tx)
+
+	// expectedCounts contains the number of keys per index for every document, by reference
+	expectedCounts := map[string][]int{}
+	if docBucket := c.documentBucket(tx); docBucket != nil {
+		cursor := docBucket.Cursor()
+		for ref, doc := cursor.First(); ref != nil; ref, doc = cursor.Next() {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			expanded, err := expand(ref, doc)
+			if err != nil {
+				return report, err
+			}
+			counts := make([]int, len(indices))
+			for j, i := range indices {
+				keys, err := distinctKeys(i, doc, expanded)
+				if err != nil {
+					return report, err
+				}
+				counts[j] = len(keys)
+				iBucket := bucket.Bucket(i.BucketName())
+				for key := range keys {
+					if !hasRef(iBucket, []byte(key), ref) {
+						report.MissingEntries = append(report.MissingEntries, newIndexEntry(i, []byte(key), ref))
+					}
+				}
+			}
+			expectedCounts[string(ref)] = counts
+		}
+	}
+
+	for j, i := range indices {
+		iBucket := bucket.Bucket(i.BucketName())
+		if iBucket == nil {
+			continue
+		}
+
+		// found counts the entries of every stored document in the index
+		found := map[string]int{}
+		err := forEachIndexEntry(ctx, iBucket, func(key []byte, ref []byte) {
+			if ref == nil {
+				report.OrphanedKeys = append(report.OrphanedKeys, newIndexEntry(i, key, nil))
+				return
+			}
+			if _, ok := expectedCounts[string(ref)]; !ok {
+				report.DanglingReferences = append(report.DanglingReferences, newIndexEntry(i, key, ref))
+				return
+			}
+			found[string(ref)]++
+		})
+		if err != nil {
+			return report, err
+		}
+
+		// all expected entries exist, so a document with more entries than keys has dangling entries
+		suspects := map[string]map[string]bool{}
+		for ref, count := range found {
+			if count <= expectedCounts[ref][j] {
+				continue
+			}
+			doc := c.documentBucket(tx).Get([]byte(ref))
+			expanded, err := expand([]byte(ref), doc)
+			if err != nil {
+				return report, err
+			}
+			if suspects[ref], err = distinctKeys(i, doc, expanded); err != nil {
+				return report, err
+			}
+		}
+		if len(suspects) == 0 {
+			continue
+		}
+		err = forEachIndexEntry(ctx, iBucket, func(key []byte, ref []byte) {
+			if keys, ok := suspects[string(ref)]; ok && !keys[string(key)] {
+				report.DanglingReferences = append(report.DanglingReferences, newIndexEntry(i, key, ref))
+			}
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// distinctKeys returns the set of keys the document should be indexed under
+func distinctKeys(i Index, doc Document, expanded []interface{}) (map[string]bool, error) {
+	keys, err := documentKeys(i, doc, expanded)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[string(key)] = true
+	}
+	return set, nil
+}
+
+// hasRef returns true if the reference is stored under the key of the index bucket
+func hasRef(iBucket *bbolt.Bucket, key []byte, ref []byte) bool {
+	if iBucket == nil {
+		return false
+	}
+	keyBucket := iBucket.Bucket(key)
+	return keyBucket != nil && keyBucket.Get(ref) != nil
+}
+
+// forEachIndexEntry calls fn for every reference in the index bucket, it's called with a nil reference for keys without references
+func forEachIndexEntry(ctx context.Context, iBucket *bbolt.Bucket, fn func(key []byte, ref []byte)) error {
+	cursor := iBucket.Cursor()
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		refs := 0
+		if keyBucket := iBucket.Bucket(key); keyBucket != nil {
+			refCursor := keyBucket.Cursor()
+			for ref, _ := refCursor.First(); ref != nil; ref, _ = refCursor.Next() {
+				refs++
+				fn(key, ref)
+			}
+		}
+		if refs == 0 {
+			fn(key, nil)
+		}
+	}
+	return nil
+}
+
+// repair only updates the entries listed in the report
+func (c *collection) repair(tx *bbolt.Tx, report VerifyReport) error {
+	bucket, err := c.createBucket(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range report.DanglingReferences {
+		if err = removeRefFromBucket(bucket.Bucket([]byte(entry.Index)), entry.Key, entry.Reference); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range report.OrphanedKeys {
+		iBucket := bucket.Bucket([]byte(entry.Index))
+		if iBucket.Bucket(entry.Key) != nil {
+			err = iBucket.DeleteBucket(entry.Key)
+		} else {
+			err = iBucket.Delete(entry.Key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range report.MissingEntries {
+		iBucket, err := bucket.CreateBucketIfNotExists([]byte(entry.Index))
+		if err != nil {
+			return err
+		}
+		if err = addRefToBucket(iBucket, entry.Key, entry.Reference); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newIndexEntry copies the key and reference since they're only valid during the transaction
+func newIndexEntry(i Index, key []byte, ref []byte) IndexEntry {
+	entry := IndexEntry{
+		Index: i.Name(),
+		Key:   append(Key{}, key...),
+	}
+	if ref != nil {
+		entry.Reference = append(Reference{}, ref...)
+	}
+	return entry
+}
@@ -0,0 +1,241 @@
+/*
+ * go-leia
+ * Copyright (C) 2021 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package goauld
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+func TestCollection_Verify(t *testing.T) {
+	ref := defaultReferenceCreator(jsonLdExample)
+	ref2 := defaultReferenceCreator(jsonLdExample2)
+
+	t.Run("ok - consistent", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.AddIndex(testIndex(t, c))
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+
+		report, err := c.Verify(context.TODO())
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, report.IsConsistent())
+	})
+
+	t.Run("ok - external index is skipped", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := externalIndex{testIndex(t, c)}
+		_ = c.AddIndex(i)
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		_ = c.Delete(jsonLdExample)
+		q := New(Eq(NewTermPath("http://schema.org/name"), ScalarMustParse("Jane Doe")))
+
+		docs, err := c.Find(context.TODO(), q)
+		report, verifyErr := c.Verify(context.TODO())
+
+		assert.NoError(t, err)
+		assert.Len(t, docs, 0)
+		if !assert.NoError(t, verifyErr) {
+			return
+		}
+		assert.True(t, report.IsConsistent())
+		// the entry of the deleted document has been removed through Index.Delete
+		_ = db.View(func(tx *bbolt.Tx) error {
+			keys := 0
+			_ = c.bucket(tx).Bucket(i.BucketName()).ForEach(func(_, _ []byte) error {
+				keys++
+				return nil
+			})
+			assert.Equal(t, 1, keys)
+			return nil
+		})
+	})
+
+	t.Run("ok - empty collection", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.AddIndex(testIndex(t, c))
+
+		report, err := c.Verify(context.TODO())
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, report.IsConsistent())
+	})
+
+	t.Run("ok - dangling reference", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := testIndex(t, c)
+		_ = c.AddIndex(i)
+		_ = c.Add([]Document{jsonLdExample})
+		_ = db.Update(func(tx *bbolt.Tx) error {
+			return c.documentBucket(tx).Delete(ref)
+		})
+
+		report, err := c.Verify(context.TODO())
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		if assert.Len(t, report.DanglingReferences, 1) {
+			assert.Equal(t, ComposeKey(nil, Key("Jane Doe")), report.DanglingReferences[0].Key)
+			assert.Equal(t, ref, report.DanglingReferences[0].Reference)
+			assert.Equal(t, i.Name(), report.DanglingReferences[0].Index)
+		}
+		assert.Len(t, report.MissingEntries, 0)
+	})
+
+	t.Run("ok - reference under the wrong key", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := testIndex(t, c)
+		_ = c.AddIndex(i)
+		_ = c.Add([]Document{jsonLdExample})
+		_ = withinBucket(t, db, func(bucket *bbolt.Bucket) error {
+			return addRefToBucket(bucket.Bucket(i.BucketName()), ComposeKey(nil, Key("John Doe")), ref)
+		})
+
+		report, err := c.Verify(context.TODO())
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		if assert.Len(t, report.DanglingReferences, 1) {
+			assert.Equal(t, ComposeKey(nil, Key("John Doe")), report.DanglingReferences[0].Key)
+		}
+	})
+
+	t.Run("ok - missing entry", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := testIndex(t, c)
+		_ = c.AddIndex(i)
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		_ = withinBucket(t, db, func(bucket *bbolt.Bucket) error {
+			return removeRefFromBucket(bucket.Bucket(i.BucketName()), ComposeKey(nil, Key("John Doe")), ref2)
+		})
+
+		report, err := c.Verify(context.TODO())
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		if assert.Len(t, report.MissingEntries, 1) {
+			assert.Equal(t, ComposeKey(nil, Key("John Doe")), report.MissingEntries[0].Key)
+			assert.Equal(t, ref2, report.MissingEntries[0].Reference)
+		}
+		assert.Len(t, report.DanglingReferences, 0)
+	})
+
+	t.Run("ok - orphaned key", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := testIndex(t, c)
+		_ = c.AddIndex(i)
+		_ = withinBucket(t, db, func(bucket *bbolt.Bucket) error {
+			iBucket, _ := bucket.CreateBucketIfNotExists(i.BucketName())
+			_, err := iBucket.CreateBucket(ComposeKey(nil, Key("John Doe")))
+			return err
+		})
+
+		report, err := c.Verify(context.TODO())
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		if assert.Len(t, report.OrphanedKeys, 1) {
+			assert.Equal(t, ComposeKey(nil, Key("John Doe")), report.OrphanedKeys[0].Key)
+			assert.Nil(t, report.OrphanedKeys[0].Reference)
+		}
+	})
+
+	t.Run("error - ctx cancelled", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		_ = c.AddIndex(testIndex(t, c))
+		_ = c.Add([]Document{jsonLdExample})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := c.Verify(ctx)
+
+		assert.Equal(t, context.Canceled, err)
+	})
+}
+
+func TestCollection_Repair(t *testing.T) {
+	ref := defaultReferenceCreator(jsonLdExample)
+	ref2 := defaultReferenceCreator(jsonLdExample2)
+
+	t.Run("ok - all inconsistencies are repaired", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := testIndex(t, c)
+		_ = c.AddIndex(i)
+		_ = c.Add([]Document{jsonLdExample, jsonLdExample2})
+		_ = db.Update(func(tx *bbolt.Tx) error {
+			iBucket := testBucket(t, tx).Bucket(i.BucketName())
+			// dangling
+			_ = addRefToBucket(iBucket, ComposeKey(nil, Key("Jack Doe")), ref)
+			// missing
+			_ = removeRefFromBucket(iBucket, ComposeKey(nil, Key("John Doe")), ref2)
+			// orphaned
+			_, err := iBucket.CreateBucket(ComposeKey(nil, Key("Jill Doe")))
+			return err
+		})
+
+		report, err := c.Repair(context.TODO())
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.False(t, report.IsConsistent())
+		report, _ = c.Verify(context.TODO())
+		assert.True(t, report.IsConsistent())
+		assertIndexed(t, db, i, ComposeKey(nil, Key("Jane Doe")), ref)
+		assertIndexed(t, db, i, ComposeKey(nil, Key("John Doe")), ref2)
+		assertIndexSize(t, db, i, 2)
+	})
+
+	t.Run("ok - missing index bucket is created", func(t *testing.T) {
+		db := testDB(t)
+		c := createCollection(db)
+		i := testIndex(t, c)
+		_ = c.Add([]Document{jsonLdExample})
+		c.IndexList = append(c.IndexList, i)
+
+		report, err := c.Repair(context.TODO())
+
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, report.MissingEntries, 1)
+		assertIndexed(t, db, i, ComposeKey(nil, Key("Jane Doe")), ref)
+	})
+}